@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+)
+
+type Args struct {
+	Command    string
+	Positional []string
+	DryRun     bool
+	Remove     bool
+	Arr        string
+}
+
+// Parses the command line, flags are accepted before and after the command
+func parseArgs(arguments []string) (Args, error) {
+	var args Args
+	flagSet := flag.NewFlagSet("arrcoon", flag.ContinueOnError)
+	flagSet.BoolVar(&args.DryRun, "dry-run", false, "Log and record torrent removals without deleting anything")
+	flagSet.BoolVar(&args.Remove, "remove", false, "Remove orphaned torrents found by the orphans command")
+	flagSet.StringVar(&args.Arr, "arr", "", "*arr the install and uninstall commands manage the connection of")
+
+	var positional []string
+	for {
+		if err := flagSet.Parse(arguments); err != nil {
+			return args, err
+		}
+		if flagSet.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flagSet.Arg(0))
+		arguments = flagSet.Args()[1:]
+	}
+	if len(positional) > 0 {
+		args.Command = positional[0]
+		args.Positional = positional[1:]
+	}
+	return args, nil
+}
@@ -3,9 +3,13 @@ package main
 import (
 	"arrcoon/arrs"
 	"arrcoon/clients"
+	"context"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/natefinch/lumberjack"
 	log "github.com/sirupsen/logrus"
@@ -21,16 +25,48 @@ type Config struct {
 		Host  string `yaml:"host"`
 		Token string `yaml:"token"`
 	} `yaml:"radarr"`
+	Lidarr struct {
+		Host  string `yaml:"host"`
+		Token string `yaml:"token"`
+	} `yaml:"lidarr"`
+	Readarr struct {
+		Host     string `yaml:"host"`
+		Token    string `yaml:"token"`
+		Instance string `yaml:"instance"`
+	} `yaml:"readarr"`
 	Clients map[string]clients.ClientConfig `yaml:"clients"`
-	Log     struct {
+	// Credentials and options of torrent clients taken from the *arr download client settings, keyed by their *arr name
+	DownloadClients map[string]clients.ClientConfig `yaml:"download_clients"`
+	DryRun          bool                            `yaml:"dry_run"`
+	KeptFiles       string                          `yaml:"kept_files"`
+	DeleteReasons   map[string]string               `yaml:"delete_reasons"`
+	Index           struct {
+		Workers           int     `yaml:"workers"`
+		RequestsPerSecond float64 `yaml:"requests_per_second"`
+		// Index database directory shared by every *arr, e.g. a volume mounted into each *arr container
+		Dir string `yaml:"dir"`
+	} `yaml:"index"`
+	Log struct {
 		Level string `yaml:"level"`
 	} `yaml:"log"`
+	Server struct {
+		Listen   string `yaml:"listen"`
+		Username string `yaml:"username"`
+		Password string `yaml:"password"`
+		// Period of index reconciles, outdated torrent sweeps and pending removal retries, e.g. 24h
+		SweepInterval string `yaml:"sweep_interval"`
+	} `yaml:"server"`
 }
 
 func main() {
 	binDir := getArcoonDir()
 	initLog(binDir)
 
+	args, err := parseArgs(os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+
 	configFile, err := os.Open(filepath.Join(binDir, "config.yml"))
 	if err != nil {
 		log.WithError(err).Error("Couldn't read config")
@@ -55,40 +91,82 @@ func main() {
 		level = log.InfoLevel
 	}
 	log.SetLevel(level)
+	discoverArrConfig(binDir, &config)
 
-	if len(config.Clients) == 0 {
-		log.Error("No torrent clients defined")
+	if _, err := arrs.ParseKeptFilesPolicy(config.KeptFiles); err != nil {
+		log.WithError(err).Error("Invalid kept_files config")
 		os.Exit(1)
 	}
-	if len(config.Clients) > 1 {
-		log.WithFields(log.Fields{
-			"Clients": config.Clients,
-		}).Error("Multiple torrent clients not supported")
+	if _, err := arrs.ParseFileDeletePolicies(config.DeleteReasons); err != nil {
+		log.WithError(err).Error("Invalid delete_reasons config")
 		os.Exit(1)
 	}
 
-	var clientType string
-	var clientConfig clients.ClientConfig
-	for k, v := range config.Clients {
-		clientType = k
-		clientConfig = v
-		break
+	// Reading the journal doesn't need torrent clients
+	if args.Command == "journal" {
+		if !journal(binDir, args.Positional) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Registering with an *arr doesn't need torrent clients either
+	if args.Command == "install" || args.Command == "uninstall" {
+		if !install(binDir, config, args.Arr, args.Command == "uninstall") {
+			os.Exit(1)
+		}
+		return
 	}
 
-	// Get Torrent Client Instance
-	constructor, clientExists := clients.Instances[clientType]
+	if len(config.Clients) == 0 {
+		config.Clients = arrDownloadClients(binDir, config)
+	}
 
-	if !clientExists {
-		log.WithFields(log.Fields{
-			"Type": clientType,
-		}).Error("Couldn't map torrent client")
+	clientOptions := clients.Options{
+		QuarantinePath: filepath.Join(binDir, ".queue", "quarantine.json"),
+	}
+	if config.DryRun || args.DryRun {
+		log.Warn("Dry run enabled, torrents won't be removed")
+		clientOptions.DryRunRecordPath = filepath.Join(binDir, "dry_run.jsonl")
+	}
+
+	configuredClient, err := clients.NewFromConfig(config.Clients, clientOptions)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"Clients": clientNames(config.Clients),
+		}).Error("Couldn't initialize torrent clients")
+		os.Exit(1)
+	}
+	// Failed and deferred removals are kept next to the index and retried on every invocation
+	torrentClient := clients.NewQueuedClient(configuredClient, clients.NewRemovalQueue(filepath.Join(binDir, ".queue", "removals.json")))
+	// The queue may hold the very torrents being restored
+	if args.Command != "restore" {
+		torrentClient.Drain(context.Background())
 	}
 
-	torrentClient := constructor(clientConfig)
+	switch args.Command {
+	case "serve":
+		if !serve(binDir, config, torrentClient) {
+			os.Exit(1)
+		}
+		return
+	case "restore":
+		if !restore(context.Background(), binDir, torrentClient, args.Positional) {
+			os.Exit(1)
+		}
+		return
+	case "orphans":
+		if !orphans(context.Background(), binDir, config, torrentClient, args.Remove) {
+			os.Exit(1)
+		}
+		return
+	}
 
 	// Get Sonarr event type
 	sonarrEventType := os.Getenv("sonarr_eventtype")
 	radarrEventType := os.Getenv("radarr_eventtype")
+	lidarrEventType := os.Getenv("lidarr_eventtype")
+	readarrEventType := os.Getenv("readarr_eventtype")
 	arrcoonEventType := os.Getenv("arrcoon_eventtype")
 
 	if arrcoonEventType != "" {
@@ -102,36 +180,190 @@ func main() {
 		}
 		return
 	}
-	if sonarrEventType == "Test" || radarrEventType == "Test" {
-		log.WithFields(log.Fields{
-			"Sonarr URL":     config.Sonarr.Host,
-			"Radarr URL":     config.Radarr.Host,
-			"Torrent Client": clientType,
-		}).Info()
-		if !torrentClient.Test() {
-			os.Exit(1)
-		}
-	}
 
+	var handled bool
 	switch {
 	case sonarrEventType != "":
-		log.WithFields(log.Fields{
-			"Sonarr EventType": sonarrEventType,
-		}).Debug()
 		sonarr := arrs.NewSonarr(binDir, config.Sonarr.Host, config.Sonarr.Token, torrentClient)
-		sonarr.HandleEvent(sonarrEventType)
+		sonarr.SetIndexOptions(config.indexOptions())
+		sonarr.SetRemovalPolicy(config.removalPolicy())
+		handled = handleEvent(context.Background(), config, torrentClient, "Sonarr", sonarr, sonarrEventType, arrs.EnvEventVars())
 	case radarrEventType != "":
-		log.WithFields(log.Fields{
-			"Radarr EventType": radarrEventType,
-		}).Debug()
 		radarr := arrs.NewRadarr(binDir, config.Radarr.Host, config.Radarr.Token, torrentClient)
-		radarr.HandleEvent(radarrEventType)
+		radarr.SetIndexOptions(config.indexOptions())
+		radarr.SetRemovalPolicy(config.removalPolicy())
+		handled = handleEvent(context.Background(), config, torrentClient, "Radarr", radarr, radarrEventType, arrs.EnvEventVars())
+	case lidarrEventType != "":
+		lidarr := arrs.NewLidarr(binDir, config.Lidarr.Host, config.Lidarr.Token, torrentClient)
+		lidarr.SetIndexOptions(config.indexOptions())
+		lidarr.SetRemovalPolicy(config.removalPolicy())
+		handled = handleEvent(context.Background(), config, torrentClient, "Lidarr", lidarr, lidarrEventType, arrs.EnvEventVars())
+	case readarrEventType != "":
+		readarr := arrs.NewReadarr(binDir, config.Readarr.Instance, config.Readarr.Host, config.Readarr.Token, torrentClient)
+		readarr.SetIndexOptions(config.indexOptions())
+		readarr.SetRemovalPolicy(config.removalPolicy())
+		handled = handleEvent(context.Background(), config, torrentClient, "Readarr", readarr, readarrEventType, arrs.EnvEventVars())
 	default:
-		log.Warn("Neither Sonarr nor Radarr events found")
+		log.Warn("No *arr events found")
+	}
+	if !handled {
 		os.Exit(1)
 	}
 }
 
+// Dispatches a single *arr event, the torrent client is validated on Test events
+func handleEvent(ctx context.Context, config Config, torrentClient *clients.QueuedClient, arrName string, handler arrs.EventHandler, eventType string, vars arrs.EventVars) bool {
+	log.WithFields(log.Fields{
+		arrName + " EventType": eventType,
+	}).Debug()
+	if eventType == "Test" {
+		log.WithFields(log.Fields{
+			"Sonarr URL":      config.Sonarr.Host,
+			"Radarr URL":      config.Radarr.Host,
+			"Lidarr URL":      config.Lidarr.Host,
+			"Readarr URL":     config.Readarr.Host,
+			"Torrent Clients": clientNames(config.Clients),
+		}).Info()
+		if !torrentClient.Test() {
+			return false
+		}
+	}
+	return handler.HandleEvent(ctx, eventType, vars)
+}
+
+// Fills the host and token of the *arr arrcoon is installed into when config.yml omits them,
+// so a rotated API key is picked up with the next event
+func discoverArrConfig(binDir string, config *Config) {
+	arrConfig, err := arrs.DiscoverArrConfig(binDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.WithError(err).Warn("Couldn't read *arr config.xml")
+		return
+	}
+	name := arrConfig.Name
+	if name == "" {
+		// Older *arrs don't write their instance name, the event tells which one runs arrcoon
+		for _, arr := range []string{"sonarr", "radarr", "lidarr", "readarr"} {
+			if os.Getenv(arr+"_eventtype") != "" {
+				name = arr
+				break
+			}
+		}
+	}
+	var host, token *string
+	switch name {
+	case "sonarr":
+		host, token = &config.Sonarr.Host, &config.Sonarr.Token
+	case "radarr":
+		host, token = &config.Radarr.Host, &config.Radarr.Token
+	case "lidarr":
+		host, token = &config.Lidarr.Host, &config.Lidarr.Token
+	case "readarr":
+		host, token = &config.Readarr.Host, &config.Readarr.Token
+	default:
+		log.Debug("Couldn't tell which *arr config.xml belongs to")
+		return
+	}
+	if *host != "" && *token != "" {
+		return
+	}
+	if *host == "" {
+		*host = arrConfig.Host
+	}
+	if *token == "" {
+		*token = arrConfig.Token
+	}
+	log.WithFields(log.Fields{
+		"Arr":  name,
+		"Host": *host,
+	}).Info("Using *arr config.xml for omitted connection settings")
+}
+
+// Implemented by *arrs exposing their download client settings
+type downloadClientSource interface {
+	DownloadClients(overrides map[string]clients.ClientConfig) (map[string]clients.ClientConfig, error)
+}
+
+// Pulls torrent clients from the download client settings of the configured *arrs, used when config.yml doesn't define any
+func arrDownloadClients(binDir string, config Config) map[string]clients.ClientConfig {
+	var names []string
+	var sources []downloadClientSource
+	if config.Sonarr.Host != "" {
+		names = append(names, "sonarr")
+		sources = append(sources, arrs.NewSonarr(binDir, config.Sonarr.Host, config.Sonarr.Token, nil))
+	}
+	if config.Radarr.Host != "" {
+		names = append(names, "radarr")
+		sources = append(sources, arrs.NewRadarr(binDir, config.Radarr.Host, config.Radarr.Token, nil))
+	}
+	if config.Lidarr.Host != "" {
+		names = append(names, "lidarr")
+		sources = append(sources, arrs.NewLidarr(binDir, config.Lidarr.Host, config.Lidarr.Token, nil))
+	}
+	if config.Readarr.Host != "" {
+		names = append(names, "readarr")
+		sources = append(sources, arrs.NewReadarr(binDir, config.Readarr.Instance, config.Readarr.Host, config.Readarr.Token, nil))
+	}
+
+	configs := make(map[string]clients.ClientConfig)
+	// Config keys by host, Sonarr and Radarr usually share the same torrent client
+	hostKeys := make(map[string]string)
+	for i, source := range sources {
+		downloadClients, err := source.DownloadClients(config.DownloadClients)
+		if err != nil {
+			continue
+		}
+		for name, clientConfig := range downloadClients {
+			host, _ := clientConfig["host"].(string)
+			// Every *arr name of the shared client routes to it
+			if key, ok := hostKeys[host]; ok {
+				configs[key]["name"] = append(configs[key]["name"].([]string), name)
+				continue
+			}
+			key := strings.ToLower(name)
+			if _, ok := configs[key]; ok {
+				key = names[i] + "_" + key
+			}
+			clientConfig["name"] = []string{name}
+			configs[key] = clientConfig
+			hostKeys[host] = key
+		}
+	}
+	log.WithFields(log.Fields{
+		"Clients": clientNames(configs),
+	}).Info("Using torrent clients of the *arr download client settings")
+	return configs
+}
+
+func (c Config) indexOptions() arrs.IndexOptions {
+	return arrs.IndexOptions{
+		Workers:           c.Index.Workers,
+		RequestsPerSecond: c.Index.RequestsPerSecond,
+		Dir:               c.Index.Dir,
+	}
+}
+
+func (c Config) removalPolicy() arrs.RemovalPolicy {
+	// Validated on startup
+	keptFiles, _ := arrs.ParseKeptFilesPolicy(c.KeptFiles)
+	fileDeletes, _ := arrs.ParseFileDeletePolicies(c.DeleteReasons)
+	return arrs.RemovalPolicy{
+		KeptFiles:   keptFiles,
+		FileDeletes: fileDeletes,
+	}
+}
+
+func clientNames(clientConfigs map[string]clients.ClientConfig) []string {
+	names := make([]string, 0, len(clientConfigs))
+	for name := range clientConfigs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func getArcoonDir() string {
 	binPath, err := os.Executable()
 	if err != nil {
@@ -0,0 +1,69 @@
+package arrs
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Settings of an *arr installation read from its config.xml
+type ArrConfig struct {
+	// Lowercased *arr name, e.g. sonarr, empty when config.xml doesn't tell
+	Name  string
+	Host  string
+	Token string
+}
+
+type arrConfigXml struct {
+	ApiKey       string `xml:"ApiKey"`
+	Port         int    `xml:"Port"`
+	SslPort      int    `xml:"SslPort"`
+	EnableSsl    bool   `xml:"EnableSsl"`
+	UrlBase      string `xml:"UrlBase"`
+	BindAddress  string `xml:"BindAddress"`
+	InstanceName string `xml:"InstanceName"`
+}
+
+// Reads config.xml of the *arr installation arrcoon is installed into, i.e. <arr config>/arrcoon
+func DiscoverArrConfig(appDir string) (ArrConfig, error) {
+	configPath := filepath.Join(filepath.Dir(appDir), "config.xml")
+	xmlBytes, err := os.ReadFile(configPath)
+	if err != nil {
+		return ArrConfig{}, err
+	}
+	var configXml arrConfigXml
+	if err := xml.Unmarshal(xmlBytes, &configXml); err != nil {
+		return ArrConfig{}, fmt.Errorf("parsing %s: %w", configPath, err)
+	}
+	if configXml.ApiKey == "" || configXml.Port == 0 {
+		return ArrConfig{}, errors.New("config.xml doesn't have ApiKey and Port")
+	}
+
+	scheme, port := "http", configXml.Port
+	if configXml.EnableSsl && configXml.SslPort != 0 {
+		scheme, port = "https", configXml.SslPort
+	}
+	// arrcoon runs on the same machine, wildcard bind addresses are reachable via loopback
+	address := configXml.BindAddress
+	if address == "" || address == "*" || net.ParseIP(address).IsUnspecified() {
+		address = "localhost"
+	}
+	host := scheme + "://" + net.JoinHostPort(address, strconv.Itoa(port))
+	if urlBase := strings.Trim(configXml.UrlBase, "/"); urlBase != "" {
+		host += "/" + urlBase
+	}
+
+	var name string
+	for _, arr := range []string{"sonarr", "radarr", "lidarr", "readarr"} {
+		if strings.Contains(strings.ToLower(configXml.InstanceName), arr) {
+			name = arr
+			break
+		}
+	}
+	return ArrConfig{Name: name, Host: host, Token: configXml.ApiKey}, nil
+}
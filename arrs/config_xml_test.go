@@ -0,0 +1,52 @@
+package arrs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeArrConfig(t *testing.T, content string) string {
+	arrDir := t.TempDir()
+	appDir := filepath.Join(arrDir, "arrcoon")
+	assert.NoError(t, os.MkdirAll(appDir, os.ModePerm))
+	assert.NoError(t, os.WriteFile(filepath.Join(arrDir, "config.xml"), []byte(content), 0644))
+	return appDir
+}
+
+func TestDiscoverArrConfig(t *testing.T) {
+	appDir := writeArrConfig(t, `<Config>
+  <BindAddress>*</BindAddress>
+  <Port>8989</Port>
+  <SslPort>9898</SslPort>
+  <EnableSsl>False</EnableSsl>
+  <ApiKey>0123456789abcdef</ApiKey>
+  <UrlBase>/sonarr</UrlBase>
+  <InstanceName>Sonarr</InstanceName>
+</Config>`)
+
+	arrConfig, err := DiscoverArrConfig(appDir)
+	assert.NoError(t, err)
+	assert.Equal(t, ArrConfig{Name: "sonarr", Host: "http://localhost:8989/sonarr", Token: "0123456789abcdef"}, arrConfig)
+}
+
+func TestDiscoverArrConfigWithSsl(t *testing.T) {
+	appDir := writeArrConfig(t, `<Config>
+  <BindAddress>192.168.1.10</BindAddress>
+  <Port>7878</Port>
+  <SslPort>6969</SslPort>
+  <EnableSsl>True</EnableSsl>
+  <ApiKey>fedcba9876543210</ApiKey>
+</Config>`)
+
+	arrConfig, err := DiscoverArrConfig(appDir)
+	assert.NoError(t, err)
+	assert.Equal(t, ArrConfig{Host: "https://192.168.1.10:6969", Token: "fedcba9876543210"}, arrConfig)
+}
+
+func TestDiscoverArrConfigWithoutConfigXml(t *testing.T) {
+	_, err := DiscoverArrConfig(t.TempDir())
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
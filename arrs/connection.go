@@ -0,0 +1,151 @@
+package arrs
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/go-resty/resty/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+// Name of the Custom Script connection created by arrcoon install
+const ConnectionName = "arrcoon"
+
+// Connection triggers matching the events handled by HandleEvent
+var sonarrTriggers = []string{"onGrab", "onDownload", "onUpgrade", "onEpisodeFileDelete", "onEpisodeFileDeleteForUpgrade", "onSeriesDelete"}
+var radarrTriggers = []string{"onGrab", "onDownload", "onUpgrade", "onMovieFileDelete", "onMovieFileDeleteForUpgrade", "onMovieDelete"}
+
+type NotificationField struct {
+	Name  string `json:"name"`
+	Value any    `json:"value"`
+}
+
+type NotificationResource struct {
+	Id             int                 `json:"id"`
+	Name           string              `json:"name"`
+	Implementation string              `json:"implementation"`
+	Fields         []NotificationField `json:"fields"`
+}
+
+// Implemented by *arrs arrcoon can register itself with
+type Connectable interface {
+	// Creates or updates the Custom Script connection running the script and tests it, which builds the index
+	Install(scriptPath string) bool
+	// Removes the Custom Script connection and drops the index
+	Uninstall() bool
+}
+
+func (s *Sonarr) Install(scriptPath string) bool {
+	return installConnection(s.restClient, "api/v3/notification", scriptPath, sonarrTriggers)
+}
+
+func (s *Sonarr) Uninstall() bool {
+	return uninstallConnection(s.restClient, "api/v3/notification") && s.index.dropIndex()
+}
+
+func (r *Radarr) Install(scriptPath string) bool {
+	return installConnection(r.restClient, "api/v3/notification", scriptPath, radarrTriggers)
+}
+
+func (r *Radarr) Uninstall() bool {
+	return uninstallConnection(r.restClient, "api/v3/notification") && r.index.dropIndex()
+}
+
+func installConnection(restClient *resty.Client, path string, scriptPath string, triggers []string) bool {
+	existing, err := findConnection(restClient, path)
+	if err != nil {
+		return false
+	}
+	connection := map[string]any{
+		"name":           ConnectionName,
+		"implementation": "CustomScript",
+		"configContract": "CustomScriptSettings",
+		"fields": []NotificationField{
+			{Name: "path", Value: scriptPath},
+			{Name: "arguments", Value: ""},
+		},
+		"tags": []int{},
+	}
+	for _, trigger := range triggers {
+		connection[trigger] = true
+	}
+
+	if existing != nil {
+		connection["id"] = existing.Id
+	}
+
+	// Saving is forced as the *arr would run the test on save as well, building the index twice
+	request := restClient.R().SetQueryParam("forceSave", "true").SetBody(connection)
+	var response *resty.Response
+	if existing != nil {
+		response, err = request.Put(path + "/" + strconv.Itoa(existing.Id))
+	} else {
+		response, err = request.Post(path)
+	}
+	if err == nil && response.IsError() {
+		err = errors.New("unexpected response status " + response.Status() + ": " + response.String())
+	}
+	if err != nil {
+		log.WithError(err).Error("Couldn't save arrcoon connection")
+		return false
+	}
+	log.WithFields(log.Fields{
+		"Script Path": scriptPath,
+		"Triggers":    triggers,
+		"Updated":     existing != nil,
+	}).Info("arrcoon connection has been saved")
+
+	log.Info("Testing arrcoon connection, the index is built meanwhile")
+	response, err = restClient.R().SetBody(connection).Post(path + "/test")
+	if err == nil && response.IsError() {
+		err = errors.New("unexpected response status " + response.Status() + ": " + response.String())
+	}
+	if err != nil {
+		log.WithError(err).Error("arrcoon connection test failed")
+		return false
+	}
+	log.Info("arrcoon connection test succeeded")
+	return true
+}
+
+func uninstallConnection(restClient *resty.Client, path string) bool {
+	existing, err := findConnection(restClient, path)
+	if err != nil {
+		return false
+	}
+	if existing == nil {
+		log.Info("arrcoon connection doesn't exist")
+		return true
+	}
+	response, err := restClient.R().Delete(path + "/" + strconv.Itoa(existing.Id))
+	if err == nil && response.IsError() {
+		err = errors.New("unexpected response status " + response.Status())
+	}
+	if err != nil {
+		log.WithError(err).Error("Couldn't remove arrcoon connection")
+		return false
+	}
+	log.WithFields(log.Fields{
+		"Id": existing.Id,
+	}).Info("arrcoon connection has been removed")
+	return true
+}
+
+// Returns the Custom Script connection named arrcoon, nil when there's none
+func findConnection(restClient *resty.Client, path string) (*NotificationResource, error) {
+	var connections []NotificationResource
+	response, err := restClient.R().SetResult(&connections).Get(path)
+	if err == nil && response.IsError() {
+		err = errors.New("unexpected response status " + response.Status())
+	}
+	if err != nil {
+		log.WithError(err).Error("Error making request")
+		return nil, err
+	}
+	for _, connection := range connections {
+		if connection.Implementation == "CustomScript" && connection.Name == ConnectionName {
+			return &connection, nil
+		}
+	}
+	return nil, nil
+}
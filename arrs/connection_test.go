@@ -0,0 +1,70 @@
+package arrs
+
+import (
+	"testing"
+
+	"github.com/h2non/gock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSonarrInstallCreatesConnection(t *testing.T) {
+	defer gock.Off()
+	testUrl := "http://localhost"
+
+	sonarr := NewSonarr(t.TempDir(), testUrl, "testtoken", nil)
+	gock.InterceptClient(sonarr.restClient.GetClient())
+	gock.New(testUrl).Get("/api/v3/notification").Reply(200).
+		JSON(`[{"id": 1, "name": "Discord", "implementation": "Discord", "fields": []}]`)
+	connection := map[string]any{
+		"name":                          ConnectionName,
+		"implementation":                "CustomScript",
+		"configContract":                "CustomScriptSettings",
+		"fields":                        []map[string]any{{"name": "path", "value": "/config/arrcoon/arrcoon"}, {"name": "arguments", "value": ""}},
+		"tags":                          []int{},
+		"onGrab":                        true,
+		"onDownload":                    true,
+		"onUpgrade":                     true,
+		"onEpisodeFileDelete":           true,
+		"onEpisodeFileDeleteForUpgrade": true,
+		"onSeriesDelete":                true,
+	}
+	gock.New(testUrl).Post("/api/v3/notification").MatchParam("forceSave", "true").JSON(connection).Reply(201)
+	gock.New(testUrl).Post("/api/v3/notification/test").JSON(connection).Reply(200)
+
+	assert.True(t, sonarr.Install("/config/arrcoon/arrcoon"))
+	assert.True(t, gock.IsDone())
+}
+
+func TestRadarrInstallUpdatesConnection(t *testing.T) {
+	defer gock.Off()
+	testUrl := "http://localhost"
+
+	radarr := NewRadarr(t.TempDir(), testUrl, "testtoken", nil)
+	gock.InterceptClient(radarr.restClient.GetClient())
+	gock.New(testUrl).Get("/api/v3/notification").Reply(200).
+		JSON(`[{"id": 5, "name": "arrcoon", "implementation": "CustomScript", "fields": []}]`)
+	gock.New(testUrl).Put("/api/v3/notification/5").MatchParam("forceSave", "true").Reply(202)
+	gock.New(testUrl).Post("/api/v3/notification/test").Reply(400).
+		JSON(`[{"propertyName": "Path", "errorMessage": "File does not exist"}]`)
+
+	assert.False(t, radarr.Install("/config/arrcoon/arrcoon"))
+	assert.True(t, gock.IsDone())
+}
+
+func TestSonarrUninstallRemovesConnectionAndIndex(t *testing.T) {
+	defer gock.Off()
+	testUrl := "http://localhost"
+
+	sonarr := NewSonarr(t.TempDir(), testUrl, "testtoken", nil)
+	gock.InterceptClient(sonarr.restClient.GetClient())
+	sonarr.index.saveIndexFile(sonarrIndexFileName(85), IndexFile{Hashes: []string{"AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"}})
+	gock.New(testUrl).Get("/api/v3/notification").Reply(200).
+		JSON(`[{"id": 5, "name": "arrcoon", "implementation": "CustomScript", "fields": []}]`)
+	gock.New(testUrl).Delete("/api/v3/notification/5").Reply(200)
+
+	assert.True(t, sonarr.Uninstall())
+	assert.True(t, gock.IsDone())
+	hashes, err := sonarr.index.hashes()
+	assert.NoError(t, err)
+	assert.Empty(t, hashes)
+}
@@ -0,0 +1,162 @@
+package arrs
+
+import (
+	"arrcoon/clients"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+// Value the *arr API returns instead of stored passwords
+const maskedPassword = "********"
+
+type DownloadClientField struct {
+	Name  string `json:"name"`
+	Value any    `json:"value"`
+}
+
+type DownloadClientResource struct {
+	Enable         bool                  `json:"enable"`
+	Protocol       string                `json:"protocol"`
+	Name           string                `json:"name"`
+	Implementation string                `json:"implementation"`
+	Fields         []DownloadClientField `json:"fields"`
+}
+
+// Torrent client types by *arr download client implementation
+var downloadClientTypes = map[string]string{
+	"qbittorrent":  "qbittorrent",
+	"rtorrent":     "rtorrent",
+	"transmission": "transmission",
+	// Vuze is driven through the Transmission RPC
+	"vuze":   "transmission",
+	"deluge": "deluge",
+}
+
+func (s *Sonarr) DownloadClients(overrides map[string]clients.ClientConfig) (map[string]clients.ClientConfig, error) {
+	return getDownloadClients(s.restClient, "api/v3/downloadclient", overrides)
+}
+
+func (r *Radarr) DownloadClients(overrides map[string]clients.ClientConfig) (map[string]clients.ClientConfig, error) {
+	return getDownloadClients(r.restClient, "api/v3/downloadclient", overrides)
+}
+
+func (l *Lidarr) DownloadClients(overrides map[string]clients.ClientConfig) (map[string]clients.ClientConfig, error) {
+	return getDownloadClients(l.restClient, "api/v1/downloadclient", overrides)
+}
+
+func (r *Readarr) DownloadClients(overrides map[string]clients.ClientConfig) (map[string]clients.ClientConfig, error) {
+	return getDownloadClients(r.restClient, "api/v1/downloadclient", overrides)
+}
+
+// Builds torrent client configs from enabled torrent download clients of the *arr, keyed by their *arr name.
+// Overrides are keyed by the *arr name too, their username and password replace the ones of the *arr.
+func getDownloadClients(restClient *resty.Client, path string, overrides map[string]clients.ClientConfig) (map[string]clients.ClientConfig, error) {
+	var downloadClients []DownloadClientResource
+	response, err := restClient.R().SetResult(&downloadClients).Get(path)
+	if err == nil && response.IsError() {
+		err = errors.New("unexpected response status " + response.Status())
+	}
+	if err != nil {
+		log.WithError(err).Error("Error making request")
+		return nil, err
+	}
+	configs := make(map[string]clients.ClientConfig)
+	for _, downloadClient := range downloadClients {
+		if !downloadClient.Enable || downloadClient.Protocol != "torrent" {
+			continue
+		}
+		config, err := downloadClient.clientConfig(downloadClientOverride(overrides, downloadClient.Name))
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"Download Client": downloadClient.Name,
+			}).Warn("Skipping download client")
+			continue
+		}
+		configs[downloadClient.Name] = config
+	}
+	return configs, nil
+}
+
+// Names of download clients are matched ignoring the case, as routing by history does
+func downloadClientOverride(overrides map[string]clients.ClientConfig, name string) clients.ClientConfig {
+	for overrideName, override := range overrides {
+		if strings.EqualFold(overrideName, name) {
+			return override
+		}
+	}
+	return nil
+}
+
+func (dc DownloadClientResource) clientConfig(override clients.ClientConfig) (clients.ClientConfig, error) {
+	clientType, ok := downloadClientTypes[strings.ToLower(dc.Implementation)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported implementation %s", dc.Implementation)
+	}
+	fields := make(map[string]any, len(dc.Fields))
+	for _, field := range dc.Fields {
+		fields[field.Name] = field.Value
+	}
+	host, _ := fields["host"].(string)
+	if host == "" {
+		return nil, errors.New("download client has no host")
+	}
+
+	endpoint := url.URL{Scheme: "http", Host: host}
+	if useSsl, _ := fields["useSsl"].(bool); useSsl {
+		endpoint.Scheme = "https"
+	}
+	// JSON numbers are decoded as float64
+	if port, ok := fields["port"].(float64); ok && port > 0 {
+		endpoint.Host = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	urlBase, _ := fields["urlBase"].(string)
+	endpoint.Path = "/" + strings.Trim(urlBase, "/")
+	if clientType == "transmission" {
+		// The *arrs append the RPC path to the url base themselves
+		endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + "/rpc"
+	}
+
+	username, _ := fields["username"].(string)
+	password, _ := fields["password"].(string)
+	if overrideUsername, ok := override["username"].(string); ok {
+		username = overrideUsername
+	}
+	if overridePassword, ok := override["password"].(string); ok {
+		password = overridePassword
+	}
+	if password == maskedPassword {
+		log.WithFields(log.Fields{
+			"Download Client": dc.Name,
+		}).Warn("The *arr API doesn't reveal the download client password, connecting without it. Set it under download_clients in config.yml")
+		password = ""
+	}
+	switch {
+	case clientType == "rtorrent":
+		// Basic auth isn't supported by the rTorrent client
+	case password != "":
+		endpoint.User = url.UserPassword(username, password)
+	case username != "":
+		endpoint.User = url.User(username)
+	}
+
+	config := clients.ClientConfig{
+		"type": clientType,
+		"host": strings.TrimSuffix(endpoint.String(), "/"),
+		// Routes removals by the downloadClient of the *arr history
+		"name": dc.Name,
+	}
+	// Other client options, e.g. quarantine or retention, apply as in the clients section
+	for key, value := range override {
+		if key != "username" && key != "password" {
+			config[key] = value
+		}
+	}
+	return config, nil
+}
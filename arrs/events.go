@@ -0,0 +1,181 @@
+package arrs
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Custom script variables of an *arr event, e.g. sonarr_series_id
+type EventVars map[string]string
+
+// Prefixes of custom script variables passed by the supported *arrs
+var eventVarPrefixes = []string{"sonarr_", "radarr_", "lidarr_", "readarr_"}
+
+// Collects *arr custom script variables from the process environment
+func EnvEventVars() EventVars {
+	vars := make(EventVars)
+	for _, env := range os.Environ() {
+		key, value, found := strings.Cut(env, "=")
+		if !found {
+			continue
+		}
+		for _, prefix := range eventVarPrefixes {
+			if strings.HasPrefix(key, prefix) {
+				vars[key] = value
+				break
+			}
+		}
+	}
+	return vars
+}
+
+type WebhookItem struct {
+	Id    int    `json:"id"`
+	Title string `json:"title"`
+	Name  string `json:"name"`
+}
+
+type WebhookFile struct {
+	Id int `json:"id"`
+}
+
+type SonarrWebhookPayload struct {
+	EventType    string        `json:"eventType"`
+	Series       WebhookItem   `json:"series"`
+	Episodes     []WebhookItem `json:"episodes"`
+	EpisodeFile  WebhookFile   `json:"episodeFile"`
+	DownloadId   string        `json:"downloadId"`
+	DeletedFiles *bool         `json:"deletedFiles"`
+	DeleteReason string        `json:"deleteReason"`
+}
+
+type RadarrWebhookPayload struct {
+	EventType    string      `json:"eventType"`
+	Movie        WebhookItem `json:"movie"`
+	MovieFile    WebhookFile `json:"movieFile"`
+	DownloadId   string      `json:"downloadId"`
+	DeletedFiles *bool       `json:"deletedFiles"`
+	DeleteReason string      `json:"deleteReason"`
+}
+
+type LidarrWebhookPayload struct {
+	EventType    string        `json:"eventType"`
+	Artist       WebhookItem   `json:"artist"`
+	Album        WebhookItem   `json:"album"`
+	Albums       []WebhookItem `json:"albums"`
+	Tracks       []WebhookItem `json:"tracks"`
+	DownloadId   string        `json:"downloadId"`
+	DeletedFiles *bool         `json:"deletedFiles"`
+}
+
+type ReadarrWebhookPayload struct {
+	EventType    string        `json:"eventType"`
+	Author       WebhookItem   `json:"author"`
+	Book         WebhookItem   `json:"book"`
+	Books        []WebhookItem `json:"books"`
+	DownloadId   string        `json:"downloadId"`
+	DeletedFiles *bool         `json:"deletedFiles"`
+}
+
+// Maps a Sonarr webhook connection payload onto custom script variables
+func SonarrWebhookVars(body []byte) (string, EventVars, error) {
+	var payload SonarrWebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", nil, err
+	}
+	episodeIds := make([]string, len(payload.Episodes))
+	for i, episode := range payload.Episodes {
+		episodeIds[i] = strconv.Itoa(episode.Id)
+	}
+	vars := EventVars{
+		"sonarr_eventtype":                payload.EventType,
+		"sonarr_series_id":                strconv.Itoa(payload.Series.Id),
+		"sonarr_series_title":             payload.Series.Title,
+		"sonarr_download_id":              payload.DownloadId,
+		"sonarr_episodefile_id":           strconv.Itoa(payload.EpisodeFile.Id),
+		"sonarr_episodefile_episodeids":   strings.Join(episodeIds, ","),
+		"sonarr_episodefile_deletereason": payload.DeleteReason,
+	}
+	setDeletedFiles(vars, "sonarr_series_deletedfiles", payload.DeletedFiles)
+	return payload.EventType, vars, nil
+}
+
+// Maps a Radarr webhook connection payload onto custom script variables
+func RadarrWebhookVars(body []byte) (string, EventVars, error) {
+	var payload RadarrWebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", nil, err
+	}
+	vars := EventVars{
+		"radarr_eventtype":              payload.EventType,
+		"radarr_movie_id":               strconv.Itoa(payload.Movie.Id),
+		"radarr_movie_title":            payload.Movie.Title,
+		"radarr_download_id":            payload.DownloadId,
+		"radarr_moviefile_id":           strconv.Itoa(payload.MovieFile.Id),
+		"radarr_moviefile_deletereason": payload.DeleteReason,
+	}
+	setDeletedFiles(vars, "radarr_movie_deletedfiles", payload.DeletedFiles)
+	return payload.EventType, vars, nil
+}
+
+// Maps a Lidarr webhook connection payload onto custom script variables
+func LidarrWebhookVars(body []byte) (string, EventVars, error) {
+	var payload LidarrWebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", nil, err
+	}
+	albumId := payload.Album.Id
+	if albumId == 0 && len(payload.Albums) > 0 {
+		albumId = payload.Albums[0].Id
+	}
+	trackIds := make([]string, len(payload.Tracks))
+	for i, track := range payload.Tracks {
+		trackIds[i] = strconv.Itoa(track.Id)
+	}
+	vars := EventVars{
+		"lidarr_eventtype":          payload.EventType,
+		"lidarr_artist_id":          strconv.Itoa(payload.Artist.Id),
+		"lidarr_artist_name":        payload.Artist.Name,
+		"lidarr_album_id":           strconv.Itoa(albumId),
+		"lidarr_download_id":        payload.DownloadId,
+		"lidarr_trackfile_trackids": strings.Join(trackIds, ","),
+	}
+	setDeletedFiles(vars, "lidarr_artist_deletedfiles", payload.DeletedFiles)
+	return payload.EventType, vars, nil
+}
+
+// Maps a Readarr webhook connection payload onto custom script variables
+func ReadarrWebhookVars(body []byte) (string, EventVars, error) {
+	var payload ReadarrWebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", nil, err
+	}
+	bookId := payload.Book.Id
+	if bookId == 0 && len(payload.Books) > 0 {
+		bookId = payload.Books[0].Id
+	}
+	vars := EventVars{
+		"readarr_eventtype":   payload.EventType,
+		"readarr_author_id":   strconv.Itoa(payload.Author.Id),
+		"readarr_author_name": payload.Author.Name,
+		"readarr_book_id":     strconv.Itoa(bookId),
+		"readarr_download_id": payload.DownloadId,
+	}
+	setDeletedFiles(vars, "readarr_author_deletedfiles", payload.DeletedFiles)
+	return payload.EventType, vars, nil
+}
+
+// Payloads without deletedFiles leave the variable unset, which reads as files deleted like on older *arr versions
+func setDeletedFiles(vars EventVars, name string, deletedFiles *bool) {
+	if deletedFiles != nil {
+		vars[name] = strconv.FormatBool(*deletedFiles)
+	}
+}
+
+// Handles *arr events, implemented by every *arr integration
+type EventHandler interface {
+	HandleEvent(ctx context.Context, event string, vars EventVars) bool
+}
@@ -0,0 +1,58 @@
+package arrs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSonarrWebhookVars(t *testing.T) {
+	body := []byte(`{
+		"eventType": "EpisodeFileDelete",
+		"series": {"id": 85, "title": "Severance"},
+		"episodes": [{"id": 3752}, {"id": 3753}],
+		"episodeFile": {"id": 1512},
+		"deleteReason": "upgrade"
+	}`)
+
+	eventType, vars, err := SonarrWebhookVars(body)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "EpisodeFileDelete", eventType)
+	assert.Equal(t, "85", vars["sonarr_series_id"])
+	assert.Equal(t, "1512", vars["sonarr_episodefile_id"])
+	assert.Equal(t, "3752,3753", vars["sonarr_episodefile_episodeids"])
+	assert.Equal(t, "upgrade", vars["sonarr_episodefile_deletereason"])
+}
+
+func TestRadarrWebhookVars(t *testing.T) {
+	body := []byte(`{
+		"eventType": "Grab",
+		"movie": {"id": 12, "title": "Dune"},
+		"downloadId": "AAA65110BA16EF7839C27604B41AB083C832D83C"
+	}`)
+
+	eventType, vars, err := RadarrWebhookVars(body)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Grab", eventType)
+	assert.Equal(t, "12", vars["radarr_movie_id"])
+	assert.Equal(t, "Dune", vars["radarr_movie_title"])
+	assert.Equal(t, "AAA65110BA16EF7839C27604B41AB083C832D83C", vars["radarr_download_id"])
+}
+
+func TestInvalidWebhookPayload(t *testing.T) {
+	_, _, err := SonarrWebhookVars([]byte(`{`))
+	assert.Error(t, err)
+}
+
+func TestWebhookVarsWithoutDeletedFiles(t *testing.T) {
+	_, vars, err := SonarrWebhookVars([]byte(`{"eventType": "SeriesDelete", "series": {"id": 85}}`))
+	assert.NoError(t, err)
+	assert.NotContains(t, vars, "sonarr_series_deletedfiles")
+	assert.True(t, defaultRemovalPolicy.removesTorrents(vars["sonarr_series_deletedfiles"]))
+
+	_, vars, err = RadarrWebhookVars([]byte(`{"eventType": "MovieDelete", "movie": {"id": 12}, "deletedFiles": false}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "false", vars["radarr_movie_deletedfiles"])
+}
@@ -2,109 +2,393 @@ package arrs
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
 )
 
+// Parallel events wait for each other rather than failing to open the index database
+const indexOpenTimeout = time.Minute
+
+// Hashes of torrents grabbed for a series, movie, artist or author
 type IndexFile struct {
 	Hashes []string `json:"hashes"`
 }
 
+// Key-value storage of index files, keys are named after the item, e.g. series_85
+type IndexStore interface {
+	// Returns false when there's no index file for the key
+	Get(key string) (IndexFile, bool, error)
+	Put(key string, indexFile IndexFile) error
+	// Stores all index files in a single transaction
+	PutAll(indexFiles map[string]IndexFile) error
+	Delete(key string) error
+	// Calls fn for every index file until it returns an error
+	ForEach(fn func(key string, indexFile IndexFile) error) error
+	Drop() error
+	// Returns index files of every *arr referencing the hash
+	References(hash string) ([]Reference, error)
+}
+
+// Index of a single *arr instance, stored as a bucket of the shared index database
 type Index struct {
-	name string
-	path string
+	name    string
+	path    string
+	options IndexOptions
 }
 
+var _ IndexStore = (*Index)(nil)
+
 func NewIndex(name string, path string) *Index {
 	return &Index{
-		name: name,
-		path: path,
+		name:    name,
+		path:    path,
+		options: defaultIndexOptions,
 	}
 }
 
-func (i *Index) saveIndexFile(name string, indexFile IndexFile) bool {
-	indexFilePath := filepath.Join(i.indexPath(), name+".json")
-	// Ensure the sonarr indexes directory exists
-	logDir := filepath.Dir(indexFilePath)
-	err := os.MkdirAll(logDir, os.ModePerm)
+// Zero values keep the defaults
+func (i *Index) setOptions(options IndexOptions) {
+	if options.Workers > 0 {
+		i.options.Workers = options.Workers
+	}
+	if options.RequestsPerSecond > 0 {
+		i.options.RequestsPerSecond = options.RequestsPerSecond
+	}
+	if options.Dir != "" {
+		i.options.Dir = options.Dir
+	}
+}
 
-	if err != nil {
+func (i *Index) Get(key string) (IndexFile, bool, error) {
+	var indexFile IndexFile
+	var found bool
+	err := i.view(func(bucket *bolt.Bucket) error {
+		value := bucket.Get([]byte(key))
+		if value == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(value, &indexFile)
+	})
+	return indexFile, found, err
+}
+
+func (i *Index) Put(key string, indexFile IndexFile) error {
+	return i.PutAll(map[string]IndexFile{key: indexFile})
+}
+
+func (i *Index) PutAll(indexFiles map[string]IndexFile) error {
+	return i.update(func(bucket *bolt.Bucket) error {
+		for key, indexFile := range indexFiles {
+			if err := i.putIndexFile(bucket, key, indexFile); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (i *Index) Delete(key string) error {
+	return i.update(func(bucket *bolt.Bucket) error {
+		if err := i.updateReferences(bucket, key, nil); err != nil {
+			return err
+		}
+		return bucket.Delete([]byte(key))
+	})
+}
+
+func (i *Index) ForEach(fn func(key string, indexFile IndexFile) error) error {
+	return i.view(func(bucket *bolt.Bucket) error {
+		return bucket.ForEach(func(key, value []byte) error {
+			var indexFile IndexFile
+			if err := json.Unmarshal(value, &indexFile); err != nil {
+				return fmt.Errorf("index file %s: %w", key, err)
+			}
+			return fn(string(key), indexFile)
+		})
+	})
+}
+
+func (i *Index) Drop() error {
+	return i.update(func(bucket *bolt.Bucket) error {
+		err := bucket.ForEach(func(key, _ []byte) error {
+			return i.updateReferences(bucket, string(key), nil)
+		})
+		if err != nil {
+			return err
+		}
+		// The next reconcile refreshes every item
+		if watermarks := bucket.Tx().Bucket([]byte(watermarksBucket)); watermarks != nil {
+			if err := watermarks.Delete([]byte(i.name)); err != nil {
+				return err
+			}
+		}
+		return bucket.Tx().DeleteBucket([]byte(i.name))
+	})
+}
+
+func (i *Index) saveIndexFile(name string, indexFile IndexFile) bool {
+	if err := i.Put(name, indexFile); err != nil {
 		log.WithError(err).WithFields(log.Fields{
-			"File Path": indexFilePath,
-		}).Error("Failed to create a directory for index file")
+			"Index": i.name,
+			"Key":   name,
+		}).Error("Error saving index file")
 		return false
 	}
+	log.WithFields(log.Fields{
+		"Index": i.name,
+		"Key":   name,
+	}).Debug("Index file has been upserted")
+	return true
+}
 
-	jsonBytes, err := json.Marshal(indexFile)
-	if err != nil {
+func (i *Index) saveIndexFiles(indexFiles map[string]IndexFile) bool {
+	if err := i.PutAll(indexFiles); err != nil {
 		log.WithError(err).WithFields(log.Fields{
-			"File Path": indexFilePath,
-		}).Error("Error marshaling JSON")
+			"Index": i.name,
+		}).Error("Error saving index files")
 		return false
 	}
+	return true
+}
 
-	err = os.WriteFile(indexFilePath, jsonBytes, 0644)
+// Takes an exclusive lock on the index database shared with other arrcoon processes, the returned function releases it.
+// Read-modify-write sequences have to hold it as *arrs fire events for a whole season in parallel.
+// The lock covers every index of the database, references of a torrent may change from any of them.
+func (i *Index) lock() func() {
+	lockPath := i.databasePath() + ".lock"
+	if err := os.MkdirAll(filepath.Dir(lockPath), os.ModePerm); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"File Path": lockPath,
+		}).Error("Failed to create a directory for index lock")
+		return func() {}
+	}
+	file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
 		log.WithError(err).WithFields(log.Fields{
-			"File Path": indexFilePath,
-		}).Error("Error writing to file")
-		return false
+			"File Path": lockPath,
+		}).Error("Error opening index lock")
+		return func() {}
+	}
+	if err := lockFile(file); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"File Path": lockPath,
+		}).Error("Error locking index")
+		file.Close()
+		return func() {}
+	}
+	return func() {
+		unlockFile(file)
+		file.Close()
 	}
-	log.WithFields(log.Fields{
-		"File Path": indexFilePath,
-	}).Debug("Index file has been upserted")
-	return true
 }
 
 func (i *Index) readIndexFile(name string) IndexFile {
-	indexFilePath := filepath.Join(i.indexPath(), name+".json")
-	jsonBytes, err := os.ReadFile(indexFilePath)
+	indexFile, found, err := i.Get(name)
 	if err != nil {
 		log.WithError(err).WithFields(log.Fields{
-			"File Path": indexFilePath,
-		}).Error("Error reading file")
+			"Index": i.name,
+			"Key":   name,
+		}).Error("Error reading index file")
 		return IndexFile{}
 	}
-	var indexFile IndexFile
-	err = json.Unmarshal(jsonBytes, &indexFile)
+	if !found {
+		log.WithFields(log.Fields{
+			"Index": i.name,
+			"Key":   name,
+		}).Error("Index file doesn't exist")
+	}
+	return indexFile
+}
+
+// Reads the index file when it exists, a missing file is an empty index rather than an error
+func (i *Index) readExistingIndexFile(name string) IndexFile {
+	indexFile, _, err := i.Get(name)
 	if err != nil {
 		log.WithError(err).WithFields(log.Fields{
-			"File Path": indexFilePath,
-		}).Error("Error unmarshaling JSON")
-		return IndexFile{}
+			"Index": i.name,
+			"Key":   name,
+		}).Error("Error reading index file")
 	}
 	return indexFile
 }
 
 func (i *Index) removeIndexFile(name string) {
-	indexFilePath := filepath.Join(i.indexPath(), name+".json")
-	err := os.Remove(indexFilePath)
-	if err != nil {
+	if err := i.Delete(name); err != nil {
 		log.WithError(err).WithFields(log.Fields{
-			"File Path": indexFilePath,
+			"Index": i.name,
+			"Key":   name,
 		}).Error("Error removing index file")
+		return
 	}
 	log.WithFields(log.Fields{
-		"File Path": indexFilePath,
+		"Index": i.name,
+		"Key":   name,
 	}).Info("Index file was removed")
 }
 
-func (i *Index) indexPath() string {
-	return filepath.Join(i.path, ".index", i.name)
+// Collects hashes of all index files, keyed by the uppercased hash
+func (i *Index) hashes() (map[string]struct{}, error) {
+	hashes := make(map[string]struct{})
+	err := i.ForEach(func(key string, indexFile IndexFile) error {
+		for _, hash := range indexFile.Hashes {
+			hashes[strings.ToUpper(hash)] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hashes, nil
 }
 
-func (i *Index) dropIndex() {
-	indexPath := i.indexPath()
-	err := os.RemoveAll(indexPath)
-	if err != nil {
+func (i *Index) dropIndex() bool {
+	if err := i.Drop(); err != nil {
 		log.WithError(err).WithFields(log.Fields{
-			"Index Path": indexPath,
-		}).Error("Error removing index directory")
-		return
+			"Index": i.name,
+		}).Error("Error dropping index")
+		return false
 	}
 	log.WithFields(log.Fields{
-		"Index Path": indexPath,
+		"Index": i.name,
 	}).Info("Index dropped")
+	return true
+}
+
+// Directory of index files used before the index database, migrated on first use
+func (i *Index) indexPath() string {
+	return filepath.Join(i.path, ".index", i.name)
+}
+
+func (i *Index) databasePath() string {
+	if i.options.Dir != "" {
+		return filepath.Join(i.options.Dir, "index.db")
+	}
+	return filepath.Join(i.path, ".index", "index.db")
+}
+
+// The database is opened for every transaction, as event processes run in parallel and bbolt locks the whole file
+func (i *Index) open() (*bolt.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(i.databasePath()), os.ModePerm); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(i.databasePath(), 0644, &bolt.Options{Timeout: indexOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("opening index database %s: %w", i.databasePath(), err)
+	}
+	return db, nil
+}
+
+func (i *Index) view(fn func(bucket *bolt.Bucket) error) error {
+	db, err := i.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := i.ensureBucket(db); err != nil {
+		return err
+	}
+	return db.View(func(tx *bolt.Tx) error {
+		return fn(tx.Bucket([]byte(i.name)))
+	})
+}
+
+func (i *Index) update(fn func(bucket *bolt.Bucket) error) error {
+	db, err := i.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := i.ensureBucket(db); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return fn(tx.Bucket([]byte(i.name)))
+	})
+}
+
+func (i *Index) putIndexFile(bucket *bolt.Bucket, key string, indexFile IndexFile) error {
+	if err := i.updateReferences(bucket, key, indexFile.Hashes); err != nil {
+		return err
+	}
+	jsonBytes, err := json.Marshal(indexFile)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(key), jsonBytes)
+}
+
+// Creates the bucket of the index, importing index files of the former per-item JSON layout
+func (i *Index) ensureBucket(db *bolt.DB) error {
+	var exists bool
+	db.View(func(tx *bolt.Tx) error {
+		exists = tx.Bucket([]byte(i.name)) != nil && tx.Bucket([]byte(referencesBucket)) != nil
+		return nil
+	})
+	if exists {
+		return nil
+	}
+	var migrated int
+	err := db.Update(func(tx *bolt.Tx) error {
+		if err := ensureReferences(tx); err != nil {
+			return err
+		}
+		if tx.Bucket([]byte(i.name)) != nil {
+			return nil
+		}
+		bucket, err := tx.CreateBucket([]byte(i.name))
+		if err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(i.indexPath())
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+				continue
+			}
+			jsonBytes, err := os.ReadFile(filepath.Join(i.indexPath(), entry.Name()))
+			if err != nil {
+				return err
+			}
+			var indexFile IndexFile
+			if err := json.Unmarshal(jsonBytes, &indexFile); err != nil {
+				log.WithError(err).WithFields(log.Fields{
+					"File Path": filepath.Join(i.indexPath(), entry.Name()),
+				}).Warn("Skipping malformed index file")
+				continue
+			}
+			if err := i.putIndexFile(bucket, strings.TrimSuffix(entry.Name(), ".json"), indexFile); err != nil {
+				return err
+			}
+			migrated++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if migrated > 0 {
+		log.WithFields(log.Fields{
+			"Index":       i.name,
+			"Index Files": migrated,
+		}).Info("Index files have been migrated to the index database")
+		if err := os.RemoveAll(i.indexPath()); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"Index Path": i.indexPath(),
+			}).Warn("Error removing migrated index files")
+		}
+	}
+	return nil
 }
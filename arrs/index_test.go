@@ -0,0 +1,161 @@
+package arrs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestConcurrentIndexWritesKeepEveryHash(t *testing.T) {
+	appDir := t.TempDir()
+	index := NewIndex("sonarr", appDir)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			index.addHashes(sonarrIndexFileName(85), []string{fmt.Sprintf("%040X", i)})
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Len(t, index.readIndexFile(sonarrIndexFileName(85)).Hashes, 20)
+}
+
+func TestIndexFilesAreMigratedToDatabase(t *testing.T) {
+	appDir := t.TempDir()
+	hash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+	legacyPath := filepath.Join(appDir, ".index", "radarr")
+	assert.NoError(t, os.MkdirAll(legacyPath, os.ModePerm))
+	assert.NoError(t, os.WriteFile(filepath.Join(legacyPath, "movie_1.json"), []byte(`{"hashes":["`+hash+`"]}`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(legacyPath, "movie_2.json"), []byte(`{"hashes":[]}`), 0644))
+
+	index := NewIndex("radarr", appDir)
+	indexFile, found, err := index.Get(radarrIndexFileName(1))
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, IndexFile{Hashes: []string{hash}}, indexFile)
+
+	var keys []string
+	assert.NoError(t, index.ForEach(func(key string, indexFile IndexFile) error {
+		keys = append(keys, key)
+		return nil
+	}))
+	assert.Equal(t, []string{"movie_1", "movie_2"}, keys)
+	assert.NoDirExists(t, legacyPath)
+
+	// Other *arrs keep their own buckets
+	_, found, err = NewIndex("sonarr", appDir).Get(radarrIndexFileName(1))
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestDropIndexRemovesOnlyItsBucket(t *testing.T) {
+	appDir := t.TempDir()
+	radarrIndex := NewIndex("radarr", appDir)
+	sonarrIndex := NewIndex("sonarr", appDir)
+	assert.True(t, radarrIndex.saveIndexFile(radarrIndexFileName(1), IndexFile{Hashes: []string{"A"}}))
+	assert.True(t, sonarrIndex.saveIndexFile(sonarrIndexFileName(1), IndexFile{Hashes: []string{"B"}}))
+
+	assert.NoError(t, radarrIndex.Drop())
+
+	radarrHashes, err := radarrIndex.hashes()
+	assert.NoError(t, err)
+	assert.Empty(t, radarrHashes)
+	sonarrHashes, err := sonarrIndex.hashes()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{"B": {}}, sonarrHashes)
+}
+
+func TestReferencesFollowIndexFiles(t *testing.T) {
+	appDir := t.TempDir()
+	hash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+	index := NewIndex("sonarr", appDir)
+
+	assert.True(t, index.saveIndexFile(sonarrIndexFileName(1), IndexFile{Hashes: []string{hash}}))
+	assert.True(t, index.saveIndexFile(sonarrIndexFileName(2), IndexFile{Hashes: []string{hash}}))
+	references, err := index.References(hash)
+	assert.NoError(t, err)
+	assert.Equal(t, []Reference{{Index: "sonarr", Key: "series_1"}, {Index: "sonarr", Key: "series_2"}}, references)
+
+	assert.True(t, index.saveIndexFile(sonarrIndexFileName(1), IndexFile{}))
+	index.removeIndexFile(sonarrIndexFileName(2))
+	references, err = index.References(hash)
+	assert.NoError(t, err)
+	assert.Empty(t, references)
+}
+
+func TestReferencesAreFilledForExistingDatabase(t *testing.T) {
+	appDir := t.TempDir()
+	hash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+	index := NewIndex("radarr", appDir)
+	db, err := index.open()
+	assert.NoError(t, err)
+	assert.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucket([]byte("radarr"))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte("movie_1"), []byte(`{"hashes":["`+hash+`"]}`))
+	}))
+	assert.NoError(t, db.Close())
+
+	references, err := index.References(hash)
+	assert.NoError(t, err)
+	assert.Equal(t, []Reference{{Index: "radarr", Key: "movie_1"}}, references)
+}
+
+func TestFetchHashesBoundsWorkersAndRate(t *testing.T) {
+	index := NewIndex("sonarr", t.TempDir())
+	index.setOptions(IndexOptions{Workers: 3, RequestsPerSecond: 200})
+
+	var fetches []indexFetch
+	for itemId := 0; itemId < 20; itemId++ {
+		fetches = append(fetches, indexFetch{itemId: itemId, key: sonarrIndexFileName(itemId)})
+	}
+	var running, maxRunning atomic.Int32
+	started := time.Now()
+	indexFiles, failed := index.fetchHashes(fetches, func(itemId int, indexed []string) ([]string, error) {
+		current := running.Add(1)
+		defer running.Add(-1)
+		for {
+			previous := maxRunning.Load()
+			if current <= previous || maxRunning.CompareAndSwap(previous, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return []string{fmt.Sprintf("%040X", itemId)}, nil
+	})
+
+	assert.Zero(t, failed)
+	assert.Len(t, indexFiles, 20)
+	assert.Equal(t, IndexFile{Hashes: []string{fmt.Sprintf("%040X", 7)}}, indexFiles[sonarrIndexFileName(7)])
+	assert.LessOrEqual(t, maxRunning.Load(), int32(3))
+	// 20 requests at 200 per second
+	assert.GreaterOrEqual(t, time.Since(started), 95*time.Millisecond)
+}
+
+func TestReferencesAreSharedThroughIndexDir(t *testing.T) {
+	indexDir := t.TempDir()
+	hash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+	// Every *arr runs arrcoon from its own directory
+	sonarrIndex := NewIndex("sonarr", t.TempDir())
+	radarrIndex := NewIndex("radarr", t.TempDir())
+	sonarrIndex.setOptions(IndexOptions{Dir: indexDir})
+	radarrIndex.setOptions(IndexOptions{Dir: indexDir})
+
+	assert.True(t, sonarrIndex.saveIndexFile(sonarrIndexFileName(1), IndexFile{Hashes: []string{hash}}))
+	assert.True(t, radarrIndex.saveIndexFile(radarrIndexFileName(1), IndexFile{Hashes: []string{hash}}))
+
+	assert.Empty(t, sonarrIndex.unsharedHashes(sonarrIndexFileName(1), []string{hash}))
+	assert.FileExists(t, filepath.Join(indexDir, "index.db"))
+}
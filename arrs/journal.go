@@ -0,0 +1,142 @@
+package arrs
+
+import (
+	"arrcoon/clients"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// History entry a removal decision was based on
+type JournalHistory struct {
+	// Episode, movie, track or book id depending on the *arr
+	Id         int       `json:"id"`
+	DownloadId string    `json:"download_id"`
+	EventType  string    `json:"event_type"`
+	Date       time.Time `json:"date"`
+}
+
+type JournalResult struct {
+	Hash   string               `json:"hash"`
+	Status clients.RemoveStatus `json:"status"`
+	Error  string               `json:"error,omitempty"`
+}
+
+// Single line of the journal describing how an *arr event was handled
+type JournalEntry struct {
+	Time  time.Time `json:"time"`
+	Arr   string    `json:"arr"`
+	Event string    `json:"event"`
+	// Series, movie, artist or author id depending on the *arr
+	ItemId  int              `json:"item_id"`
+	History []JournalHistory `json:"history,omitempty"`
+	Hashes  []string         `json:"hashes"`
+	Results []JournalResult  `json:"results,omitempty"`
+	// Torrents the removal policy left seeding, e.g. of items deleted without their files
+	Kept []string `json:"kept,omitempty"`
+}
+
+// Torrents picked for removal along with the history entries the choice was based on
+type removal struct {
+	history []JournalHistory
+	hashes  []string
+	results clients.RemoveResults
+	kept    []string
+}
+
+// Append-only JSON Lines journal of removal decisions
+type Journal struct {
+	path string
+}
+
+func NewJournal(appDir string) *Journal {
+	return &Journal{
+		path: filepath.Join(appDir, "journal.jsonl"),
+	}
+}
+
+func (j *Journal) record(arr string, event string, itemId int, removal removal) {
+	entry := JournalEntry{
+		Time:    time.Now(),
+		Arr:     arr,
+		Event:   event,
+		ItemId:  itemId,
+		History: removal.history,
+		Hashes:  removal.hashes,
+		Kept:    removal.kept,
+	}
+	for _, result := range removal.results {
+		journalResult := JournalResult{Hash: result.Hash, Status: result.Status}
+		if result.Err != nil {
+			journalResult.Error = result.Err.Error()
+		}
+		entry.Results = append(entry.Results, journalResult)
+	}
+
+	jsonBytes, err := json.Marshal(entry)
+	if err != nil {
+		log.WithError(err).Error("Error marshaling journal entry")
+		return
+	}
+	file, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"File Path": j.path,
+		}).Error("Error opening journal")
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(jsonBytes, '\n')); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"File Path": j.path,
+		}).Error("Error writing journal")
+	}
+}
+
+// Returns journal entries mentioning the hash, or all entries when the hash is empty
+func (j *Journal) Entries(hash string) ([]JournalEntry, error) {
+	file, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(file)
+	// History of long running series doesn't fit into the default line limit
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.WithError(err).Warn("Skipping malformed journal entry")
+			continue
+		}
+		if hash == "" || entry.mentions(hash) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+func (je JournalEntry) mentions(hash string) bool {
+	for _, entryHash := range append(je.Hashes, je.Kept...) {
+		if strings.EqualFold(entryHash, hash) {
+			return true
+		}
+	}
+	for _, history := range je.History {
+		if strings.EqualFold(history.DownloadId, hash) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package arrs
+
+import (
+	"arrcoon/clients"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestJournalEntries(t *testing.T) {
+	removedHash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+	failedHash := "BBBBB4F4132C4AC7031F5692F36AC77A2ECBCCBB"
+	journal := NewJournal(t.TempDir())
+
+	journal.record("sonarr", "Download", 85, removal{
+		history: []JournalHistory{{Id: 3752, DownloadId: removedHash, EventType: "downloadFolderImported"}},
+		hashes:  []string{removedHash},
+		results: clients.RemoveResults{{Hash: removedHash, Status: clients.StatusRemoved}},
+	})
+	journal.record("radarr", "MovieDelete", 7, removal{
+		hashes:  []string{failedHash},
+		results: clients.RemoveResults{{Hash: failedHash, Status: clients.StatusFailed, Err: errors.New("connection refused")}},
+	})
+
+	entries, err := journal.Entries("")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	entries, err = journal.Entries("bbbbb4f4132c4ac7031f5692f36ac77a2eccbcbb")
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	entries, err = journal.Entries("bbbbb4f4132c4ac7031f5692f36ac77a2ecbccbb")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "radarr", entries[0].Arr)
+	assert.Equal(t, "MovieDelete", entries[0].Event)
+	assert.Equal(t, 7, entries[0].ItemId)
+	assert.Equal(t, []JournalResult{{Hash: failedHash, Status: clients.StatusFailed, Error: "connection refused"}}, entries[0].Results)
+}
+
+func TestSeriesDeleteIsJournaled(t *testing.T) {
+	hash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+
+	mockTorrentClient := &MockTorrentClient{}
+	mockTorrentClient.On("RemoveTorrents", []string{hash}).Return(clients.RemoveResults{{Hash: hash, Status: clients.StatusRemoved}})
+
+	sonarr := NewSonarr(t.TempDir(), "http://localhost", "testtoken", mockTorrentClient)
+	sonarr.index.saveIndexFile(sonarrIndexFileName(85), IndexFile{Hashes: []string{hash}})
+
+	assert.True(t, sonarr.HandleEvent(context.Background(), "SeriesDelete", EventVars{"sonarr_series_id": "85"}))
+
+	entries, err := sonarr.journal.Entries(hash)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "sonarr", entries[0].Arr)
+	assert.Equal(t, 85, entries[0].ItemId)
+	assert.Equal(t, []JournalResult{{Hash: hash, Status: clients.StatusRemoved}}, entries[0].Results)
+	mock.AssertExpectationsForObjects(t, mockTorrentClient)
+}
@@ -0,0 +1,286 @@
+package arrs
+
+import (
+	"arrcoon/clients"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+type Lidarr struct {
+	appDir        string
+	torrentClient clients.TorrentClient
+	restClient    *resty.Client
+	index         Index
+	journal       *Journal
+	policy        RemovalPolicy
+	files         mediaFiles
+}
+
+type LidarrApiResponse struct {
+	Current string `json:"current"`
+}
+
+type LidarrHistorySinceResponse struct {
+	ArtistId int `json:"artistId"`
+}
+
+type LidarrArtistResponse struct {
+	Id int `json:"id"`
+}
+
+type LidarrArtistHistoryResponse struct {
+	AlbumId    int         `json:"albumId"`
+	TrackId    int         `json:"trackId"`
+	DownloadId string      `json:"downloadId"`
+	Date       time.Time   `json:"date"`
+	EventType  string      `json:"eventType"`
+	Data       HistoryData `json:"data"`
+}
+
+func NewLidarr(appDir string, host string, token string, torrentClient clients.TorrentClient) *Lidarr {
+	lidarr := &Lidarr{
+		appDir:        appDir,
+		torrentClient: torrentClient,
+		restClient:    resty.New().SetBaseURL(host).SetHeader(AUTH_HEADER, token),
+		index:         *NewIndex("lidarr", appDir),
+		journal:       NewJournal(appDir),
+		policy:        defaultRemovalPolicy,
+	}
+	lidarr.files = mediaFiles{
+		torrentClient: torrentClient,
+		index:         &lidarr.index,
+		itemName:      "Artist",
+		fileName:      "Track",
+		importedEvent: "trackFileImported",
+		deletedEvent:  "trackFileDeleted",
+		key:           lidarrIndexFileName,
+		history:       lidarr.getArtistHistory,
+	}
+	return lidarr
+}
+
+func (l *Lidarr) SetIndexOptions(options IndexOptions) {
+	l.index.setOptions(options)
+}
+
+func (l *Lidarr) SetRemovalPolicy(policy RemovalPolicy) {
+	l.policy.merge(policy)
+}
+
+func (l *Lidarr) HandleEvent(ctx context.Context, event string, vars EventVars) bool {
+	switch event {
+	case "Test":
+		log.Debug("Handling Test event")
+		if !l.testApi() {
+			return false
+		}
+		return l.buildIndex()
+	case "Grab":
+		artistIdString := vars["lidarr_artist_id"]
+		downloadId := vars["lidarr_download_id"]
+		artistName := vars["lidarr_artist_name"]
+		log.WithFields(log.Fields{
+			"lidarr_artist_id":   artistIdString,
+			"lidarr_download_id": downloadId,
+			"lidarr_artist_name": artistName,
+		}).Debug("Handling Grab event")
+		artistId, err := strconv.Atoi(artistIdString)
+		if err != nil {
+			log.WithError(err).Error("Failed to convert lidarr_artist_id to int")
+			return false
+		}
+		if isValidTorrentHash(downloadId) {
+			l.files.updateIndexFile(artistId, downloadId)
+		}
+	case "Download", "AlbumDownload", "AlbumImport":
+		artistIdString := vars["lidarr_artist_id"]
+		log.WithFields(log.Fields{
+			"lidarr_artist_id": artistIdString,
+		}).Debugf("Handling %s event", event)
+		artistId, err := strconv.Atoi(artistIdString)
+		if err != nil {
+			log.WithError(err).Error("Failed to convert lidarr_artist_id to int")
+			return false
+		}
+		l.journal.record(l.index.name, event, artistId, l.files.removeOutdatedTorrents(ctx, artistId, nil))
+	case "TrackFileDelete":
+		artistIdString := vars["lidarr_artist_id"]
+		deletedTrackIdsString := vars["lidarr_trackfile_trackids"]
+		log.WithFields(log.Fields{
+			"lidarr_artist_id":          artistIdString,
+			"lidarr_trackfile_trackids": deletedTrackIdsString,
+		}).Debug("Handling TrackFileDelete event")
+		artistId, err := strconv.Atoi(artistIdString)
+		if err != nil {
+			log.WithError(err).Error("Failed to convert lidarr_artist_id to int")
+			return false
+		}
+		trackIdsParts := strings.Split(deletedTrackIdsString, ",")
+		deletedTrackId, err := strconv.Atoi(strings.TrimSpace(trackIdsParts[0]))
+		if err != nil {
+			log.WithError(err).Error("Failed to convert lidarr_trackfile_trackids to int")
+			return false
+		}
+		l.journal.record(l.index.name, event, artistId, l.files.removeOutdatedTorrents(ctx, artistId, &deletedTrackId))
+	case "AlbumDelete":
+		artistIdString := vars["lidarr_artist_id"]
+		albumId := vars["lidarr_album_id"]
+		log.WithFields(log.Fields{
+			"lidarr_artist_id": artistIdString,
+			"lidarr_album_id":  albumId,
+		}).Debug("Handling AlbumDelete event")
+		artistId, err := strconv.Atoi(artistIdString)
+		if err != nil {
+			log.WithError(err).Error("Failed to convert lidarr_artist_id to int")
+			return false
+		}
+		l.journal.record(l.index.name, event, artistId, l.files.removeUnreferencedDownloads(ctx, artistId))
+	case "ArtistDelete":
+		artistIdString := vars["lidarr_artist_id"]
+		log.WithFields(log.Fields{
+			"lidarr_artist_id": artistIdString,
+		}).Debug("Handling ArtistDelete event")
+		artistId, err := strconv.Atoi(artistIdString)
+		if err != nil {
+			log.WithError(err).Error("Failed to convert lidarr_artist_id to int")
+			return false
+		}
+		if !l.policy.removesTorrents(vars["lidarr_artist_deletedfiles"]) {
+			l.journal.record(l.index.name, event, artistId, l.index.keepDownloads(lidarrIndexFileName(artistId)))
+			break
+		}
+		l.journal.record(l.index.name, event, artistId, l.files.removeAllDownloads(ctx, artistId))
+	default:
+		log.WithFields(log.Fields{"Event": event}).Debug("Ignoring Lidarr event type")
+	}
+	return true
+}
+
+// Reconciles the index and removes outdated torrents of every artist
+func (l *Lidarr) Sweep(ctx context.Context, locker sync.Locker) bool {
+	if !l.buildIndex() {
+		return false
+	}
+	artistIds, err := l.getArtistIds()
+	if err != nil {
+		return false
+	}
+	return l.index.sweep(ctx, l.journal, locker, l.torrentClient, artistIds, func(artistId int) removal {
+		return l.files.outdatedTorrents(artistId, nil)
+	})
+}
+
+func (l *Lidarr) testApi() bool {
+	log.Info("Testing Lidarr accessibility")
+	var apiResponse LidarrApiResponse
+	_, err := l.restClient.R().SetResult(&apiResponse).Get("api")
+	if err != nil {
+		log.WithError(err).Error("Couldn't connect to Lidarr API")
+		return false
+	}
+	log.WithFields(log.Fields{
+		"Current API Version": apiResponse.Current,
+	}).Info("Succesfully connected to Lidarr")
+	return true
+}
+
+func (l *Lidarr) getArtistIds() ([]int, error) {
+	var artists []LidarrArtistResponse
+	response, err := l.restClient.R().SetResult(&artists).Get("api/v1/artist")
+	if err == nil && response.IsError() {
+		err = errors.New("unexpected Lidarr response status " + response.Status())
+	}
+	if err != nil {
+		log.WithError(err).Error("Error making request")
+		return []int{}, err
+	}
+	artistIds := make([]int, len(artists))
+	for i, artist := range artists {
+		artistIds[i] = artist.Id
+	}
+	log.WithFields(log.Fields{
+		"Artist Ids": artistIds,
+	}).Info()
+	return artistIds, nil
+}
+
+func (l *Lidarr) getArtistHistory(artistId int) ([]mediaFileHistory, error) {
+	params := map[string]string{
+		"artistId":      strconv.Itoa(artistId),
+		"includeArtist": "false",
+		"includeAlbum":  "false",
+		"includeTrack":  "false",
+	}
+	var artistHistory []LidarrArtistHistoryResponse
+	response, err := l.restClient.R().SetQueryParams(params).SetResult(&artistHistory).Get("api/v1/history/artist")
+	if err == nil && response.IsError() {
+		err = errors.New("unexpected Lidarr response status " + response.Status())
+	}
+	if err != nil {
+		log.WithError(err).Error("Error making request")
+		return []mediaFileHistory{}, err
+	}
+	trackHistory := make([]mediaFileHistory, len(artistHistory))
+	for i, history := range artistHistory {
+		trackHistory[i] = mediaFileHistory{FileId: history.TrackId, DownloadId: history.DownloadId, Date: history.Date, EventType: history.EventType, Data: history.Data}
+	}
+	return trackHistory, nil
+}
+
+func (l *Lidarr) buildIndex() bool {
+	log.Info("Reconciling lidarr artist index...")
+	return l.index.reconcile(indexSource{
+		itemIds:        l.getArtistIds,
+		changedItemIds: l.getChangedArtistIds,
+		key:            lidarrIndexFileName,
+		hashes:         l.files.getDeduplicatedDownloadIds,
+	})
+}
+
+// Returns ids of artists with history entries since the given time
+func (l *Lidarr) getChangedArtistIds(since time.Time) ([]int, error) {
+	params := map[string]string{
+		"date":          since.UTC().Format(time.RFC3339),
+		"includeArtist": "false",
+		"includeAlbum":  "false",
+		"includeTrack":  "false",
+	}
+	var history []LidarrHistorySinceResponse
+	response, err := l.restClient.R().SetQueryParams(params).SetResult(&history).Get("api/v1/history/since")
+	if err == nil && response.IsError() {
+		err = errors.New("unexpected Lidarr response status " + response.Status())
+	}
+	if err != nil {
+		log.WithError(err).Error("Error making request")
+		return nil, err
+	}
+	seen := make(map[int]struct{})
+	var artistIds []int
+	for _, entry := range history {
+		if _, ok := seen[entry.ArtistId]; !ok {
+			seen[entry.ArtistId] = struct{}{}
+			artistIds = append(artistIds, entry.ArtistId)
+		}
+	}
+	return artistIds, nil
+}
+
+// Returns hashes referenced by the index and the history of existing artists
+func (l *Lidarr) ReferencedHashes() (map[string]struct{}, error) {
+	artistIds, err := l.getArtistIds()
+	if err != nil {
+		return nil, err
+	}
+	return l.files.referencedHashes(artistIds)
+}
+
+func lidarrIndexFileName(artistId int) string {
+	return "artist_" + strconv.Itoa(artistId)
+}
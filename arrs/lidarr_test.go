@@ -0,0 +1,63 @@
+package arrs
+
+import (
+	testutils "arrcoon/testing"
+	"context"
+	"testing"
+
+	"github.com/h2non/gock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestLidarrUpgradedAlbum(t *testing.T) {
+	defer gock.Off()
+
+	mockTorrentClient := &MockTorrentClient{}
+	// Assert that only the superseded album release is removed
+	mockTorrentClient.On("RemoveTorrents", []string{"AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"}).Return(nil)
+
+	testUrl := "http://localhost"
+
+	lidarr := NewLidarr(t.TempDir(), testUrl, "testtoken", mockTorrentClient)
+	gock.InterceptClient(lidarr.restClient.GetClient())
+
+	gock.New(testUrl).
+		Get("/api/v1/history/artist").
+		MatchParam("artistId", "7").
+		Reply(200).
+		JSON(testutils.LoadJson("lidarr_history_album_upgraded"))
+
+	assert.True(t, lidarr.HandleEvent(context.Background(), "AlbumDownload", EventVars{"lidarr_artist_id": "7"}))
+
+	assert.True(t, gock.IsDone())
+	mock.AssertExpectationsForObjects(t, mockTorrentClient)
+}
+
+func TestLidarrAlbumDelete(t *testing.T) {
+	defer gock.Off()
+
+	removedAlbumHash := "CCCCC4F4132C4AC7031F5692F36AC77A2ECBCCCC"
+	remainingAlbumHash := "BBBBB4F4132C4AC7031F5692F36AC77A2ECBCCBB"
+
+	mockTorrentClient := &MockTorrentClient{}
+	mockTorrentClient.On("RemoveTorrents", []string{removedAlbumHash}).Return(nil)
+
+	testUrl := "http://localhost"
+
+	lidarr := NewLidarr(t.TempDir(), testUrl, "testtoken", mockTorrentClient)
+	lidarr.index.saveIndexFile(lidarrIndexFileName(7), IndexFile{Hashes: []string{remainingAlbumHash, removedAlbumHash}})
+	gock.InterceptClient(lidarr.restClient.GetClient())
+
+	gock.New(testUrl).
+		Get("/api/v1/history/artist").
+		MatchParam("artistId", "7").
+		Reply(200).
+		JSON(`[{"albumId": 10, "trackId": 101, "eventType": "trackFileImported", "downloadId": "` + remainingAlbumHash + `"}]`)
+
+	assert.True(t, lidarr.HandleEvent(context.Background(), "AlbumDelete", EventVars{"lidarr_artist_id": "7", "lidarr_album_id": "11"}))
+
+	assert.True(t, gock.IsDone())
+	mock.AssertExpectationsForObjects(t, mockTorrentClient)
+	assert.Equal(t, []string{remainingAlbumHash}, lidarr.index.readIndexFile(lidarrIndexFileName(7)).Hashes)
+}
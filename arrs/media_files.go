@@ -0,0 +1,215 @@
+package arrs
+
+import (
+	"arrcoon/clients"
+	"context"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// History entry of a single media file (track, book) of a Lidarr artist or Readarr author
+type mediaFileHistory struct {
+	FileId     int
+	DownloadId string
+	Date       time.Time
+	EventType  string
+	Data       HistoryData
+}
+
+// Torrent bookkeeping of *arrs whose items (artists, authors) are made of several media files (tracks, books)
+type mediaFiles struct {
+	torrentClient clients.TorrentClient
+	index         *Index
+	// Item and media file names used in logs, e.g. Artist and Track
+	itemName string
+	fileName string
+	// History event types of imported and deleted media files, e.g. trackFileImported and trackFileDeleted
+	importedEvent string
+	deletedEvent  string
+	key           func(itemId int) string
+	history       func(itemId int) ([]mediaFileHistory, error)
+}
+
+// Removes all torrents which are not mapped to active media files
+func (m *mediaFiles) removeOutdatedTorrents(ctx context.Context, itemId int, removedFileId *int) removal {
+	removal := m.outdatedTorrents(itemId, removedFileId)
+	removal.results = m.torrentClient.RemoveTorrents(ctx, removal.hashes)
+	return removal
+}
+
+// Picks torrents which are not mapped to active media files according to the item history
+func (m *mediaFiles) outdatedTorrents(itemId int, removedFileId *int) removal {
+	itemHistory, _ := m.history(itemId)
+
+	// Collect media file imports from torrents and media file deletions
+	relevantHistory := make([]mediaFileHistory, 0)
+	for _, history := range itemHistory {
+		routeHash(m.torrentClient, history.DownloadId, history.Data)
+		if (isValidTorrentHash(history.DownloadId) && history.EventType == m.importedEvent) || history.EventType == m.deletedEvent {
+			relevantHistory = append(relevantHistory, history)
+		}
+	}
+
+	if removedFileId != nil {
+		relevantHistory = append(relevantHistory, mediaFileHistory{
+			FileId:    *removedFileId,
+			Date:      time.Now(),
+			EventType: m.deletedEvent,
+		})
+	}
+
+	// History entries to media file id, newest first
+	historyMap := make(map[int][]mediaFileHistory)
+	for _, history := range relevantHistory {
+		historyMap[history.FileId] = append(historyMap[history.FileId], history)
+	}
+	for fileId, histories := range historyMap {
+		sort.Slice(histories, func(i, j int) bool {
+			return histories[i].Date.After(histories[j].Date)
+		})
+		historyMap[fileId] = histories
+	}
+
+	log.WithFields(log.Fields{
+		m.fileName + " History": historyMap,
+	}).Trace()
+
+	relevantHashes := make(map[string]struct{})
+	outdatedHashes := make(map[string]struct{})
+
+	// The latest entry of every media file keeps its download relevant unless the media file was deleted
+	for _, histories := range historyMap {
+		if histories[0].EventType != m.deletedEvent {
+			relevantHashes[histories[0].DownloadId] = struct{}{}
+		}
+		for _, history := range histories[1:] {
+			outdatedHashes[history.DownloadId] = struct{}{}
+		}
+	}
+
+	var outdatedHashValues []string
+	for hash := range outdatedHashes {
+		if _, ok := relevantHashes[hash]; !ok && hash != "" {
+			outdatedHashValues = append(outdatedHashValues, hash)
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"Outdated Hash Values": outdatedHashValues,
+	}).Debug()
+
+	journalHistory := make([]JournalHistory, len(relevantHistory))
+	for i, history := range relevantHistory {
+		journalHistory[i] = JournalHistory{Id: history.FileId, DownloadId: history.DownloadId, EventType: history.EventType, Date: history.Date}
+	}
+	return removal{
+		history: journalHistory,
+		hashes:  outdatedHashValues,
+	}
+}
+
+// Fails along with the history request, returning only the given download ids
+func (m *mediaFiles) getDeduplicatedDownloadIds(itemId int, downloadIds []string) ([]string, error) {
+	itemHistory, err := m.history(itemId)
+	return m.deduplicateDownloadIds(itemId, itemHistory, downloadIds), err
+}
+
+func (m *mediaFiles) deduplicateDownloadIds(itemId int, itemHistory []mediaFileHistory, downloadIds []string) []string {
+	uniqueRequestedDownloadsMap := make(map[string]struct{})
+	var validTorrentHashDownloadIds []string
+	addDownloadId := func(downloadId string) {
+		if _, ok := uniqueRequestedDownloadsMap[downloadId]; ok || !isValidTorrentHash(downloadId) {
+			return
+		}
+		uniqueRequestedDownloadsMap[downloadId] = struct{}{}
+		validTorrentHashDownloadIds = append(validTorrentHashDownloadIds, downloadId)
+	}
+	for _, history := range itemHistory {
+		addDownloadId(history.DownloadId)
+	}
+	for _, downloadId := range downloadIds {
+		addDownloadId(downloadId)
+	}
+
+	log.WithFields(log.Fields{
+		m.itemName + " Id": itemId,
+		"Hashes":           validTorrentHashDownloadIds,
+	}).Debug("Deduplicated download ids")
+
+	return validTorrentHashDownloadIds
+}
+
+// Removes indexed torrents which aren't referenced by the item history any longer, e.g. after an album or book removal
+func (m *mediaFiles) removeUnreferencedDownloads(ctx context.Context, itemId int) removal {
+	defer m.index.lock()()
+	itemHistory, err := m.history(itemId)
+	// Without the history every indexed torrent would look unreferenced
+	if err != nil {
+		return removal{}
+	}
+	indexFile := m.index.readIndexFile(m.key(itemId))
+	currentHashes := m.deduplicateDownloadIds(itemId, itemHistory, nil)
+	currentHashesMap := make(map[string]struct{}, len(currentHashes))
+	for _, hash := range currentHashes {
+		currentHashesMap[hash] = struct{}{}
+	}
+
+	var unreferencedHashes []string
+	for _, hash := range indexFile.Hashes {
+		if _, ok := currentHashesMap[hash]; !ok {
+			unreferencedHashes = append(unreferencedHashes, hash)
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"Unreferenced Hash Values": unreferencedHashes,
+	}).Debug()
+
+	results := m.torrentClient.RemoveTorrents(ctx, unreferencedHashes)
+	// Failed hashes stay indexed to be retried with the next removal
+	m.index.saveIndexFile(m.key(itemId), IndexFile{Hashes: append(currentHashes, results.Failed()...)})
+	return removal{hashes: unreferencedHashes, results: results}
+}
+
+func (m *mediaFiles) removeAllDownloads(ctx context.Context, itemId int) removal {
+	defer m.index.lock()()
+	indexFile := m.index.readIndexFile(m.key(itemId))
+	hashes := m.index.unsharedHashes(m.key(itemId), indexFile.Hashes)
+	if len(hashes) == 0 {
+		m.index.removeIndexFile(m.key(itemId))
+		return removal{}
+	}
+	results := m.torrentClient.RemoveTorrents(ctx, hashes)
+	// Keep hashes which failed to be removed for a later retry
+	if failed := results.Failed(); len(failed) > 0 {
+		log.WithFields(log.Fields{
+			"Hashes": failed,
+		}).Warn("Keeping index file for torrents which couldn't be removed")
+		m.index.saveIndexFile(m.key(itemId), IndexFile{Hashes: failed})
+		return removal{hashes: hashes, results: results}
+	}
+	m.index.removeIndexFile(m.key(itemId))
+	return removal{hashes: hashes, results: results}
+}
+
+func (m *mediaFiles) updateIndexFile(itemId int, downloadId string) {
+	defer m.index.lock()()
+	// Hashes indexed by concurrent Grab events may not be in the history yet
+	indexFile := m.index.readExistingIndexFile(m.key(itemId))
+	hashes, _ := m.getDeduplicatedDownloadIds(itemId, append(indexFile.Hashes, downloadId))
+	m.index.saveIndexFile(m.key(itemId), IndexFile{Hashes: hashes})
+}
+
+// Returns hashes referenced by the index and the history of the given items
+func (m *mediaFiles) referencedHashes(itemIds []int) (map[string]struct{}, error) {
+	return referencedHashes(*m.index, itemIds, func(itemId int) ([]string, error) {
+		itemHistory, err := m.history(itemId)
+		downloadIds := make([]string, len(itemHistory))
+		for i, history := range itemHistory {
+			downloadIds[i] = history.DownloadId
+		}
+		return downloadIds, err
+	})
+}
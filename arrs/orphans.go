@@ -0,0 +1,70 @@
+package arrs
+
+import (
+	"arrcoon/clients"
+	"context"
+	"errors"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Implemented by *arrs able to list every torrent hash their library still refers to
+type Library interface {
+	ReferencedHashes() (map[string]struct{}, error)
+}
+
+// Returns torrents of the client which aren't referenced by any of the libraries.
+// Fails when a library can't be read, as every torrent would look orphaned otherwise.
+func FindOrphans(ctx context.Context, torrentClient clients.TorrentClient, libraries []Library) ([]clients.TorrentContent, error) {
+	if len(libraries) == 0 {
+		return nil, errors.New("no *arr libraries configured")
+	}
+	referenced := make(map[string]struct{})
+	for _, library := range libraries {
+		hashes, err := library.ReferencedHashes()
+		if err != nil {
+			return nil, err
+		}
+		for hash := range hashes {
+			referenced[hash] = struct{}{}
+		}
+	}
+
+	torrents, err := torrentClient.ListTorrents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var orphans []clients.TorrentContent
+	for _, torrent := range torrents {
+		if _, ok := referenced[strings.ToUpper(torrent.Hash)]; !ok {
+			orphans = append(orphans, torrent)
+		}
+	}
+	log.WithFields(log.Fields{
+		"Torrents":   len(torrents),
+		"Referenced": len(referenced),
+		"Orphans":    len(orphans),
+	}).Info("Orphan scan finished")
+	return orphans, nil
+}
+
+// Merges indexed hashes with hashes found in the history of every item
+func referencedHashes(index Index, itemIds []int, downloadIds func(itemId int) ([]string, error)) (map[string]struct{}, error) {
+	hashes, err := index.hashes()
+	if err != nil {
+		return nil, err
+	}
+	for _, itemId := range itemIds {
+		itemDownloadIds, err := downloadIds(itemId)
+		if err != nil {
+			return nil, err
+		}
+		for _, downloadId := range itemDownloadIds {
+			if isValidTorrentHash(downloadId) {
+				hashes[strings.ToUpper(downloadId)] = struct{}{}
+			}
+		}
+	}
+	return hashes, nil
+}
@@ -0,0 +1,81 @@
+package arrs
+
+import (
+	"arrcoon/clients"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/h2non/gock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type StaticLibrary struct {
+	hashes map[string]struct{}
+	err    error
+}
+
+func (sl StaticLibrary) ReferencedHashes() (map[string]struct{}, error) {
+	return sl.hashes, sl.err
+}
+
+func TestFindOrphans(t *testing.T) {
+	referencedHash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+	orphanedHash := "BBBBB4F4132C4AC7031F5692F36AC77A2ECBCCBB"
+
+	mockTorrentClient := &MockTorrentClient{}
+	mockTorrentClient.On("ListTorrents").Return([]clients.TorrentContent{
+		{Hash: "aaaaad29f161e9dd7b2bc43a53d5114760c764aa", Path: "/downloads/Show.S01"},
+		{Hash: orphanedHash, Path: "/downloads/Movie.2010"},
+	}, nil)
+
+	orphans, err := FindOrphans(context.Background(), mockTorrentClient, []Library{
+		StaticLibrary{hashes: map[string]struct{}{referencedHash: {}}},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []clients.TorrentContent{{Hash: orphanedHash, Path: "/downloads/Movie.2010"}}, orphans)
+	mock.AssertExpectationsForObjects(t, mockTorrentClient)
+}
+
+func TestFindOrphansFailsOnLibraryError(t *testing.T) {
+	mockTorrentClient := &MockTorrentClient{}
+
+	orphans, err := FindOrphans(context.Background(), mockTorrentClient, []Library{
+		StaticLibrary{err: errors.New("connection refused")},
+	})
+
+	// Nothing may be reported when a library couldn't be read
+	assert.Error(t, err)
+	assert.Empty(t, orphans)
+	mockTorrentClient.AssertNotCalled(t, "ListTorrents")
+}
+
+func TestRadarrReferencedHashes(t *testing.T) {
+	defer gock.Off()
+
+	indexedHash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+	historyHash := "BBBBB4F4132C4AC7031F5692F36AC77A2ECBCCBB"
+	testUrl := "http://localhost"
+
+	radarr := NewRadarr(t.TempDir(), testUrl, "testtoken", nil)
+	radarr.index.saveIndexFile(radarrIndexFileName(1), IndexFile{Hashes: []string{indexedHash}})
+	gock.InterceptClient(radarr.restClient.GetClient())
+
+	gock.New(testUrl).
+		Get("/api/v3/movie").
+		Reply(200).
+		JSON(`[{"id": 7}]`)
+	gock.New(testUrl).
+		Get("/api/v3/history/movie").
+		MatchParam("movieId", "7").
+		Reply(200).
+		JSON(`[{"movieId": 7, "downloadId": "` + historyHash + `", "eventType": "grabbed"}, {"movieId": 7, "downloadId": "SABnzbd_nzo_1", "eventType": "grabbed"}]`)
+
+	hashes, err := radarr.ReferencedHashes()
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{indexedHash: {}, historyHash: {}}, hashes)
+	assert.True(t, gock.IsDone())
+}
@@ -0,0 +1,170 @@
+package arrs
+
+import (
+	"arrcoon/clients"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// What happens to torrents of an item deleted from the *arr while keeping its files
+type KeptFilesPolicy string
+
+const (
+	// Keep seeding, only the index entry is dropped
+	KeptFilesKeep KeptFilesPolicy = "keep"
+	// Remove torrents just like when the files were deleted
+	KeptFilesRemove KeptFilesPolicy = "remove"
+)
+
+// What happens to the torrent of an episode or movie file deleted for a given reason
+type FileDeletePolicy struct {
+	// Keep seeding, later events and sweeps don't remove the torrent either
+	Keep bool
+	// Keep seeding for the period, the torrent is queued for removal once it is over
+	GracePeriod time.Duration
+}
+
+// Delete reasons of EpisodeFileDelete and MovieFileDelete events, normalized by normalizeDeleteReason
+var deleteReasons = map[string]struct{}{
+	"upgrade":          {},
+	"missingfromdisk":  {},
+	"manual":           {},
+	"manualoverride":   {},
+	"nolinkedepisodes": {},
+}
+
+// Decides which *arr deletions remove torrents
+type RemovalPolicy struct {
+	KeptFiles KeptFilesPolicy
+	// By normalized delete reason, reasons left out remove torrents right away
+	FileDeletes map[string]FileDeletePolicy
+}
+
+// Files missing from disk are often on a temporarily unmounted drive, their torrents keep seeding unless configured otherwise
+var defaultRemovalPolicy = RemovalPolicy{
+	KeptFiles: KeptFilesKeep,
+	FileDeletes: map[string]FileDeletePolicy{
+		"missingfromdisk": {Keep: true},
+	},
+}
+
+func ParseKeptFilesPolicy(value string) (KeptFilesPolicy, error) {
+	switch policy := KeptFilesPolicy(value); policy {
+	case "":
+		return defaultRemovalPolicy.KeptFiles, nil
+	case KeptFilesKeep, KeptFilesRemove:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown kept files policy %s, expected keep or remove", value)
+	}
+}
+
+// Parses delete reason policies: remove, keep or a grace period such as 72h
+func ParseFileDeletePolicies(values map[string]string) (map[string]FileDeletePolicy, error) {
+	policies := make(map[string]FileDeletePolicy, len(values))
+	for reason, value := range values {
+		key := normalizeDeleteReason(reason)
+		if _, ok := deleteReasons[key]; !ok {
+			return nil, fmt.Errorf("unknown delete reason %s, expected upgrade, missing_from_disk, manual, manual_override or no_linked_episodes", reason)
+		}
+		switch value {
+		case "", "remove":
+			policies[key] = FileDeletePolicy{}
+		case "keep":
+			policies[key] = FileDeletePolicy{Keep: true}
+		default:
+			gracePeriod, err := time.ParseDuration(value)
+			if err != nil || gracePeriod < 0 {
+				return nil, fmt.Errorf("unknown %s delete policy %s, expected remove, keep or a grace period", reason, value)
+			}
+			policies[key] = FileDeletePolicy{GracePeriod: gracePeriod}
+		}
+	}
+	return policies, nil
+}
+
+// Matches MissingFromDisk, missingfromdisk and missing_from_disk alike
+func normalizeDeleteReason(reason string) string {
+	return strings.ToLower(strings.ReplaceAll(reason, "_", ""))
+}
+
+// Zero values keep the defaults
+func (p *RemovalPolicy) merge(policy RemovalPolicy) {
+	if policy.KeptFiles != "" {
+		p.KeptFiles = policy.KeptFiles
+	}
+	if len(policy.FileDeletes) > 0 {
+		fileDeletes := make(map[string]FileDeletePolicy, len(p.FileDeletes)+len(policy.FileDeletes))
+		for reason, fileDelete := range p.FileDeletes {
+			fileDeletes[reason] = fileDelete
+		}
+		for reason, fileDelete := range policy.FileDeletes {
+			fileDeletes[reason] = fileDelete
+		}
+		p.FileDeletes = fileDeletes
+	}
+}
+
+// Tells whether a file deleted for the reason at the given time no longer holds its torrent back
+func (p RemovalPolicy) releasesTorrent(reason string, deleted time.Time) bool {
+	policy := p.FileDeletes[normalizeDeleteReason(reason)]
+	if policy.Keep {
+		return false
+	}
+	return time.Since(deleted) >= policy.GracePeriod
+}
+
+// Returns the grace period of files deleted for the reason, false when their torrents are kept or removed right away
+func (p RemovalPolicy) gracePeriod(reason string) (time.Duration, bool) {
+	policy := p.FileDeletes[normalizeDeleteReason(reason)]
+	return policy.GracePeriod, !policy.Keep && policy.GracePeriod > 0
+}
+
+// Queues torrents kept for a grace period for removal once it's over.
+// Without a removal queue they're left to the first event or sweep after it.
+func scheduleRemovals(torrentClient clients.TorrentClient, hashes []string, notBefore time.Time) clients.RemoveResults {
+	if len(hashes) == 0 {
+		return nil
+	}
+	scheduler, ok := torrentClient.(clients.Scheduler)
+	if !ok {
+		log.WithFields(log.Fields{
+			"Hashes": hashes,
+		}).Warn("Torrent client can't schedule removals, leaving them to later events")
+		return nil
+	}
+	return scheduler.ScheduleRemovals(hashes, notBefore)
+}
+
+// Cancels scheduled removals of a torrent imported again
+func unscheduleRemovals(torrentClient clients.TorrentClient, downloadId string) {
+	if scheduler, ok := torrentClient.(clients.Scheduler); ok && isValidTorrentHash(downloadId) {
+		scheduler.UnscheduleRemovals([]string{downloadId})
+	}
+}
+
+// Tells whether torrents of a deleted item are removed, given its deletedfiles variable.
+// Older *arrs don't pass the variable, their deletions are treated as deleting files.
+func (p RemovalPolicy) removesTorrents(deletedFiles string) bool {
+	if deleted, err := strconv.ParseBool(deletedFiles); err != nil || deleted {
+		return true
+	}
+	return p.KeptFiles == KeptFilesRemove
+}
+
+// Drops the index file of a deleted item whose files were kept, leaving its torrents seeding
+func (i *Index) keepDownloads(name string) removal {
+	defer i.lock()()
+	indexFile := i.readExistingIndexFile(name)
+	log.WithFields(log.Fields{
+		"Index":  i.name,
+		"Key":    name,
+		"Hashes": indexFile.Hashes,
+	}).Info("Files were kept, keeping torrents")
+	i.removeIndexFile(name)
+	return removal{kept: indexFile.Hashes}
+}
@@ -2,9 +2,11 @@ package arrs
 
 import (
 	"arrcoon/clients"
-	"os"
+	"context"
+	"errors"
 	"sort"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/go-resty/resty/v2"
@@ -16,21 +18,28 @@ type Radarr struct {
 	torrentClient clients.TorrentClient
 	restClient    *resty.Client
 	index         Index
+	journal       *Journal
+	policy        RemovalPolicy
 }
 
 type RadarrApiResponse struct {
 	Current string `json:"current"`
 }
 
+type RadarrHistorySinceResponse struct {
+	MovieId int `json:"movieId"`
+}
+
 type RadarrMoviesResponse struct {
 	Id int `json:"id"`
 }
 
 type RadarrMoviesHistoryResponse struct {
-	MovieId    int       `json:"movieId"`
-	DownloadId string    `json:"downloadId"`
-	Date       time.Time `json:"date"`
-	EventType  string    `json:"eventType"`
+	MovieId    int         `json:"movieId"`
+	DownloadId string      `json:"downloadId"`
+	Date       time.Time   `json:"date"`
+	EventType  string      `json:"eventType"`
+	Data       HistoryData `json:"data"`
 }
 
 func NewRadarr(appDir string, host string, token string, torrentClient clients.TorrentClient) *Radarr {
@@ -39,20 +48,31 @@ func NewRadarr(appDir string, host string, token string, torrentClient clients.T
 		torrentClient: torrentClient,
 		restClient:    resty.New().SetBaseURL(host).SetHeader(AUTH_HEADER, token),
 		index:         *NewIndex("radarr", appDir),
+		journal:       NewJournal(appDir),
+		policy:        defaultRemovalPolicy,
 	}
 }
 
-func (r *Radarr) HandleEvent(event string) {
+func (r *Radarr) SetIndexOptions(options IndexOptions) {
+	r.index.setOptions(options)
+}
+
+func (r *Radarr) SetRemovalPolicy(policy RemovalPolicy) {
+	r.policy.merge(policy)
+}
+
+func (r *Radarr) HandleEvent(ctx context.Context, event string, vars EventVars) bool {
 	switch event {
 	case "Test":
 		log.Debug("Handling Test event")
-		r.testApi()
-		r.index.dropIndex()
-		r.buildIndex()
+		if !r.testApi() {
+			return false
+		}
+		return r.buildIndex()
 	case "Grab":
-		grabbedMovieId := os.Getenv("radarr_movie_id")
-		downloadId := os.Getenv("radarr_download_id")
-		movieTitle := os.Getenv("radarr_movie_title")
+		grabbedMovieId := vars["radarr_movie_id"]
+		downloadId := vars["radarr_download_id"]
+		movieTitle := vars["radarr_movie_title"]
 		log.WithFields(log.Fields{
 			"radarr_movie_id":    grabbedMovieId,
 			"radarr_download_id": downloadId,
@@ -61,14 +81,14 @@ func (r *Radarr) HandleEvent(event string) {
 		movieId, err := strconv.Atoi(grabbedMovieId)
 		if err != nil {
 			log.WithError(err).Error("Failed to convert radarr_movie_id to int")
-			return
+			return false
 		}
 		if isValidTorrentHash(downloadId) {
 			r.updateIndexFile(movieId, downloadId)
 		}
 	case "Download":
-		downloadedMovieId := os.Getenv("radarr_movie_id")
-		downloadId := os.Getenv("radarr_download_id")
+		downloadedMovieId := vars["radarr_movie_id"]
+		downloadId := vars["radarr_download_id"]
 
 		log.WithFields(log.Fields{
 			"radarr_movie_id":    downloadedMovieId,
@@ -77,49 +97,98 @@ func (r *Radarr) HandleEvent(event string) {
 		movieId, err := strconv.Atoi(downloadedMovieId)
 		if err != nil {
 			log.WithError(err).Error("Failed to convert radarr_movie_id to int")
-			return
+			return false
 		}
+		unscheduleRemovals(r.torrentClient, downloadId)
 		// Never call removeOutdatedTorrents if downloadId is not a valid torrent hash
 		if isValidTorrentHash(downloadId) {
-			r.removeOutdatedTorrents(movieId, downloadId)
+			r.journal.record(r.index.name, event, movieId, r.removeOutdatedTorrents(ctx, movieId, downloadId))
 		}
+	case "MovieFileDelete":
+		deletedMovieId := vars["radarr_movie_id"]
+		deleteReason := vars["radarr_moviefile_deletereason"]
+		log.WithFields(log.Fields{
+			"radarr_movie_id":               deletedMovieId,
+			"radarr_moviefile_deletereason": deleteReason,
+		}).Debug("Handling MovieFileDelete event")
+		movieId, err := strconv.Atoi(deletedMovieId)
+		if err != nil {
+			log.WithError(err).Error("Failed to convert radarr_movie_id to int")
+			return false
+		}
+		if !r.policy.releasesTorrent(deleteReason, time.Now()) {
+			log.WithFields(log.Fields{
+				"Movie Id":      movieId,
+				"Delete Reason": deleteReason,
+			}).Info("Keeping torrents of the deleted movie file for now")
+			kept := r.keptTorrents(movieId)
+			if gracePeriod, ok := r.policy.gracePeriod(deleteReason); ok {
+				kept.results = scheduleRemovals(r.torrentClient, kept.kept, time.Now().Add(gracePeriod))
+			}
+			r.journal.record(r.index.name, event, movieId, kept)
+			break
+		}
+		// The movie has no file left, so no imported torrent is current
+		r.journal.record(r.index.name, event, movieId, r.removeOutdatedTorrents(ctx, movieId, ""))
 	case "MovieDelete":
-		removedMovieId := os.Getenv("radarr_movie_id")
+		removedMovieId := vars["radarr_movie_id"]
 		movieId, err := strconv.Atoi(removedMovieId)
 		if err != nil {
 			log.WithError(err).Error("Failed to convert radarr_movie_id to int")
+			return false
 		}
 		log.WithFields(log.Fields{
 			"radarr_movie_id": removedMovieId,
 		}).Debug("Handling MovieDelete event")
-		r.removeAllDownloads(movieId)
+		if !r.policy.removesTorrents(vars["radarr_movie_deletedfiles"]) {
+			r.journal.record(r.index.name, event, movieId, r.index.keepDownloads(radarrIndexFileName(movieId)))
+			break
+		}
+		r.journal.record(r.index.name, event, movieId, r.removeAllDownloads(ctx, movieId))
 	default:
 		log.WithField("event", event).Info("Ignoring Radarr event type")
 	}
+	return true
+}
+
+// Reconciles the index and removes outdated torrents of every movie
+func (r *Radarr) Sweep(ctx context.Context, locker sync.Locker) bool {
+	if !r.buildIndex() {
+		return false
+	}
+	movieIds, err := r.getMovies()
+	if err != nil {
+		return false
+	}
+	return r.index.sweep(ctx, r.journal, locker, r.torrentClient, movieIds, r.outdatedMovieTorrents)
 }
 
-func (r *Radarr) testApi() {
+func (r *Radarr) testApi() bool {
 	log.Info("Testing Radarr API")
 	var apiResponse RadarrApiResponse
 	_, err := r.restClient.R().SetResult(&apiResponse).Get("api")
 	if err != nil {
 		log.WithError(err).Error("Couldn't connect to Radarr API")
-		os.Exit(1)
+		return false
 	}
 	log.WithFields(log.Fields{
 		"Current API Version": apiResponse.Current,
 	}).Info("Succesfully connected to Radarr")
+	return true
 }
 
-func (r *Radarr) getMovies() []int {
+func (r *Radarr) getMovies() ([]int, error) {
 	params := map[string]string{
 		"excludeLocalCovers": "true",
 	}
 	var movies []RadarrMoviesResponse
-	_, err := r.restClient.R().SetQueryParams(params).SetResult(&movies).Get("api/v3/movie")
+	response, err := r.restClient.R().SetQueryParams(params).SetResult(&movies).Get("api/v3/movie")
+	if err == nil && response.IsError() {
+		err = errors.New("unexpected Radarr response status " + response.Status())
+	}
 	if err != nil {
 		log.WithError(err).Error("Error making request")
-		return []int{}
+		return []int{}, err
 	}
 	moviesIds := make([]int, len(movies))
 	for i, movie := range movies {
@@ -128,25 +197,29 @@ func (r *Radarr) getMovies() []int {
 	log.WithFields(log.Fields{
 		"Movies Ids": moviesIds,
 	}).Info()
-	return moviesIds
+	return moviesIds, nil
 }
 
-func (r *Radarr) getMovieHistory(movieId int) []RadarrMoviesHistoryResponse {
+func (r *Radarr) getMovieHistory(movieId int) ([]RadarrMoviesHistoryResponse, error) {
 	params := map[string]string{
 		"movieId":      strconv.Itoa(movieId),
 		"includeMovie": "false",
 	}
 	var moviesHistory []RadarrMoviesHistoryResponse
-	_, err := r.restClient.R().SetQueryParams(params).SetResult(&moviesHistory).Get("api/v3/history/movie")
+	response, err := r.restClient.R().SetQueryParams(params).SetResult(&moviesHistory).Get("api/v3/history/movie")
+	if err == nil && response.IsError() {
+		err = errors.New("unexpected Radarr response status " + response.Status())
+	}
 	if err != nil {
 		log.WithError(err).Error("Error making request")
-		return []RadarrMoviesHistoryResponse{}
+		return []RadarrMoviesHistoryResponse{}, err
 	}
-	return moviesHistory
+	return moviesHistory, nil
 }
 
-func (r *Radarr) getDeduplicatedDownloadIds(movies int, downloadIds []string) []string {
-	moviesHistory := r.getMovieHistory(movies)
+// Fails along with the history request, returning only the given download ids
+func (r *Radarr) getDeduplicatedDownloadIds(movies int, downloadIds []string) ([]string, error) {
+	moviesHistory, err := r.getMovieHistory(movies)
 	uniqueRequestedDownloadsMap := make(map[string]struct{})
 	var uniqueRequestedDownloadIds []string
 	for _, history := range moviesHistory {
@@ -174,38 +247,106 @@ func (r *Radarr) getDeduplicatedDownloadIds(movies int, downloadIds []string) []
 		"Hashes":    validTorrentHashDownloadIds,
 	}).Debug("Deduplicated download ids")
 
-	return validTorrentHashDownloadIds
+	return validTorrentHashDownloadIds, err
+}
+
+func (r *Radarr) buildIndex() bool {
+	log.Info("Reconciling radarr movies index...")
+	return r.index.reconcile(indexSource{
+		itemIds:        r.getMovies,
+		changedItemIds: r.getChangedMovieIds,
+		key:            radarrIndexFileName,
+		hashes:         r.getDeduplicatedDownloadIds,
+	})
+}
+
+// Returns ids of movies with history entries since the given time
+func (r *Radarr) getChangedMovieIds(since time.Time) ([]int, error) {
+	params := map[string]string{
+		"date":         since.UTC().Format(time.RFC3339),
+		"includeMovie": "false",
+	}
+	var history []RadarrHistorySinceResponse
+	response, err := r.restClient.R().SetQueryParams(params).SetResult(&history).Get("api/v3/history/since")
+	if err == nil && response.IsError() {
+		err = errors.New("unexpected Radarr response status " + response.Status())
+	}
+	if err != nil {
+		log.WithError(err).Error("Error making request")
+		return nil, err
+	}
+	seen := make(map[int]struct{})
+	var movieIds []int
+	for _, entry := range history {
+		if _, ok := seen[entry.MovieId]; !ok {
+			seen[entry.MovieId] = struct{}{}
+			movieIds = append(movieIds, entry.MovieId)
+		}
+	}
+	return movieIds, nil
 }
 
-func (r *Radarr) buildIndex() {
-	log.Info("Building radarr mvies index...")
-	moviesIds := r.getMovies()
-	var indexedMoviesCounter int
-	for _, moviesId := range moviesIds {
-		hashes := r.getDeduplicatedDownloadIds(moviesId, nil)
-		indexFile := &IndexFile{
-			Hashes: hashes,
+// Picks imported torrents of the movie except the most recent one, which holds the current movie file,
+// unless the movie file was deleted since
+func (r *Radarr) outdatedMovieTorrents(movieId int) removal {
+	movieHistory, err := r.getMovieHistory(movieId)
+	if err != nil {
+		return removal{}
+	}
+	var current, deleted RadarrMoviesHistoryResponse
+	for _, history := range movieHistory {
+		if history.EventType == "downloadFolderImported" && isValidTorrentHash(history.DownloadId) && history.Date.After(current.Date) {
+			current = history
 		}
-		if r.index.saveIndexFile("movie_"+strconv.Itoa(moviesId), *indexFile) {
-			indexedMoviesCounter++
-		} else {
-			os.Exit(1)
+		if history.EventType == "movieFileDeleted" && history.Date.After(deleted.Date) {
+			deleted = history
 		}
 	}
-	log.WithFields(log.Fields{
-		"Indexed Movies": indexedMoviesCounter,
-	}).Info("Radarr index built")
+	if current.DownloadId == "" {
+		return removal{}
+	}
+	// Deletions kept by the policy or still in their grace period don't outdate the torrent yet
+	if deleted.Date.After(current.Date) && r.policy.releasesTorrent(deleted.Data.Reason, deleted.Date) {
+		return r.outdatedTorrents(movieId, "")
+	}
+	return r.outdatedTorrents(movieId, current.DownloadId)
+}
+
+// Torrent of the deleted movie file, the most recently imported one, which the removal policy keeps seeding
+func (r *Radarr) keptTorrents(movieId int) removal {
+	movieHistory, _ := r.getMovieHistory(movieId)
+	var current RadarrMoviesHistoryResponse
+	for _, history := range movieHistory {
+		if history.EventType == "downloadFolderImported" && isValidTorrentHash(history.DownloadId) && history.Date.After(current.Date) {
+			current = history
+		}
+	}
+	if current.DownloadId == "" {
+		return removal{}
+	}
+	return removal{
+		history: []JournalHistory{{Id: movieId, DownloadId: current.DownloadId, EventType: current.EventType, Date: current.Date}},
+		kept:    []string{current.DownloadId},
+	}
 }
 
 // Removes all torrent files which are not mapped to the current movie
-func (r *Radarr) removeOutdatedTorrents(movieId int, torrentHash string) {
-	movieHistory := r.getMovieHistory(movieId)
+func (r *Radarr) removeOutdatedTorrents(ctx context.Context, movieId int, torrentHash string) removal {
+	removal := r.outdatedTorrents(movieId, torrentHash)
+	removal.results = r.torrentClient.RemoveTorrents(ctx, removal.hashes)
+	return removal
+}
+
+// Picks torrents of the movie other than the given one
+func (r *Radarr) outdatedTorrents(movieId int, torrentHash string) removal {
+	movieHistory, _ := r.getMovieHistory(movieId)
 
 	log.WithField("Movie History", movieHistory).Trace()
 
 	// Filter in elements where event type is downloadFolderImported and valid torrent hash
 	downloadFolderImportedHistory := make([]RadarrMoviesHistoryResponse, 0)
 	for _, history := range movieHistory {
+		routeHash(r.torrentClient, history.DownloadId, history.Data)
 		if history.EventType == "downloadFolderImported" && isValidTorrentHash(history.DownloadId) && torrentHash != history.DownloadId {
 			downloadFolderImportedHistory = append(downloadFolderImportedHistory, history)
 		}
@@ -231,23 +372,60 @@ func (r *Radarr) removeOutdatedTorrents(movieId int, torrentHash string) {
 		"Outdated Hash Values": outdatedHashValues,
 	}).Debug()
 
-	r.torrentClient.RemoveTorrents(outdatedHashValues)
+	journalHistory := make([]JournalHistory, len(downloadFolderImportedHistory))
+	for i, history := range downloadFolderImportedHistory {
+		journalHistory[i] = JournalHistory{Id: movieId, DownloadId: history.DownloadId, EventType: history.EventType, Date: history.Date}
+	}
+	return removal{
+		history: journalHistory,
+		hashes:  outdatedHashValues,
+	}
 }
 
 func (r *Radarr) updateIndexFile(movieId int, downloadId string) {
-	hashes := r.getDeduplicatedDownloadIds(movieId, []string{downloadId})
-	indexFile := &IndexFile{
-		Hashes: hashes,
-	}
-	r.index.saveIndexFile(radarrIndexFileName(movieId), *indexFile)
+	defer r.index.lock()()
+	// Hashes indexed by concurrent Grab events may not be in the history yet
+	indexFile := r.index.readExistingIndexFile(radarrIndexFileName(movieId))
+	hashes, _ := r.getDeduplicatedDownloadIds(movieId, append(indexFile.Hashes, downloadId))
+	indexFile.Hashes = hashes
+	r.index.saveIndexFile(radarrIndexFileName(movieId), indexFile)
 }
 
-func (r *Radarr) removeAllDownloads(movieId int) {
+func (r *Radarr) removeAllDownloads(ctx context.Context, movieId int) removal {
+	defer r.index.lock()()
 	indexFile := r.index.readIndexFile(radarrIndexFileName(movieId))
-	if len(indexFile.Hashes) > 0 {
-		r.torrentClient.RemoveTorrents(indexFile.Hashes)
+	hashes := r.index.unsharedHashes(radarrIndexFileName(movieId), indexFile.Hashes)
+	if len(hashes) == 0 {
+		r.index.removeIndexFile(radarrIndexFileName(movieId))
+		return removal{}
+	}
+	results := r.torrentClient.RemoveTorrents(ctx, hashes)
+	// Keep hashes which failed to be removed for a later retry
+	if failed := results.Failed(); len(failed) > 0 {
+		log.WithFields(log.Fields{
+			"Hashes": failed,
+		}).Warn("Keeping index file for torrents which couldn't be removed")
+		r.index.saveIndexFile(radarrIndexFileName(movieId), IndexFile{Hashes: failed})
+		return removal{hashes: hashes, results: results}
 	}
 	r.index.removeIndexFile(radarrIndexFileName(movieId))
+	return removal{hashes: hashes, results: results}
+}
+
+// Returns hashes referenced by the index and the history of existing movies
+func (r *Radarr) ReferencedHashes() (map[string]struct{}, error) {
+	movieIds, err := r.getMovies()
+	if err != nil {
+		return nil, err
+	}
+	return referencedHashes(r.index, movieIds, func(movieId int) ([]string, error) {
+		movieHistory, err := r.getMovieHistory(movieId)
+		downloadIds := make([]string, len(movieHistory))
+		for i, history := range movieHistory {
+			downloadIds[i] = history.DownloadId
+		}
+		return downloadIds, err
+	})
 }
 
 func radarrIndexFileName(movieId int) string {
@@ -0,0 +1,55 @@
+package arrs
+
+import (
+	"arrcoon/clients"
+	"context"
+	"testing"
+
+	"github.com/h2non/gock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMovieFileDeleteMissingFromDisk(t *testing.T) {
+	defer gock.Off()
+	testUrl := "http://localhost"
+	hash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+	vars := EventVars{
+		"radarr_movie_id":               "12",
+		"radarr_moviefile_deletereason": "MissingFromDisk",
+	}
+
+	// Kept seeding by default
+	mockTorrentClient := &MockTorrentClient{}
+	radarr := NewRadarr(t.TempDir(), testUrl, "testtoken", mockTorrentClient)
+	gock.InterceptClient(radarr.restClient.GetClient())
+	gock.New(testUrl).Get("/api/v3/history/movie").MatchParam("movieId", "12").Reply(200).JSON(`[
+		{"movieId": 12, "downloadId": "` + hash + `", "date": "2025-01-01T10:00:00Z", "eventType": "downloadFolderImported"}
+	]`)
+
+	assert.True(t, radarr.HandleEvent(context.Background(), "MovieFileDelete", vars))
+	assert.True(t, gock.IsDone())
+	mockTorrentClient.AssertNotCalled(t, "RemoveTorrents", mock.Anything)
+	entries, err := radarr.journal.Entries(hash)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, []string{hash}, entries[0].Kept)
+
+	// Removed when configured
+	policies, err := ParseFileDeletePolicies(map[string]string{"missing_from_disk": "remove"})
+	assert.NoError(t, err)
+	mockTorrentClient = &MockTorrentClient{}
+	mockTorrentClient.On("RemoveTorrents", []string{hash}).Return(clients.RemoveResults{
+		{Hash: hash, Status: clients.StatusRemoved},
+	})
+	radarr = NewRadarr(t.TempDir(), testUrl, "testtoken", mockTorrentClient)
+	radarr.SetRemovalPolicy(RemovalPolicy{FileDeletes: policies})
+	gock.InterceptClient(radarr.restClient.GetClient())
+	gock.New(testUrl).Get("/api/v3/history/movie").MatchParam("movieId", "12").Reply(200).JSON(`[
+		{"movieId": 12, "downloadId": "` + hash + `", "date": "2025-01-01T10:00:00Z", "eventType": "downloadFolderImported"}
+	]`)
+
+	assert.True(t, radarr.HandleEvent(context.Background(), "MovieFileDelete", vars))
+	assert.True(t, gock.IsDone())
+	mock.AssertExpectationsForObjects(t, mockTorrentClient)
+}
@@ -0,0 +1,286 @@
+package arrs
+
+import (
+	"arrcoon/clients"
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+type Readarr struct {
+	appDir        string
+	torrentClient clients.TorrentClient
+	restClient    *resty.Client
+	index         Index
+	journal       *Journal
+	policy        RemovalPolicy
+	files         mediaFiles
+}
+
+type ReadarrApiResponse struct {
+	Current string `json:"current"`
+}
+
+type ReadarrHistorySinceResponse struct {
+	AuthorId int `json:"authorId"`
+}
+
+type ReadarrAuthorResponse struct {
+	Id int `json:"id"`
+}
+
+type ReadarrAuthorHistoryResponse struct {
+	BookId     int         `json:"bookId"`
+	DownloadId string      `json:"downloadId"`
+	Date       time.Time   `json:"date"`
+	EventType  string      `json:"eventType"`
+	Data       HistoryData `json:"data"`
+}
+
+// Instance separates indexes of several Readarr installations (e.g. ebooks and audiobooks) sharing the same arrcoon directory
+func NewReadarr(appDir string, instance string, host string, token string, torrentClient clients.TorrentClient) *Readarr {
+	indexName := "readarr"
+	if instance != "" {
+		indexName += "_" + instance
+	}
+	readarr := &Readarr{
+		appDir:        appDir,
+		torrentClient: torrentClient,
+		restClient:    resty.New().SetBaseURL(host).SetHeader(AUTH_HEADER, token),
+		index:         *NewIndex(indexName, appDir),
+		journal:       NewJournal(appDir),
+		policy:        defaultRemovalPolicy,
+	}
+	readarr.files = mediaFiles{
+		torrentClient: torrentClient,
+		index:         &readarr.index,
+		itemName:      "Author",
+		fileName:      "Book",
+		importedEvent: "bookFileImported",
+		deletedEvent:  "bookFileDeleted",
+		key:           readarrIndexFileName,
+		history:       readarr.getAuthorHistory,
+	}
+	return readarr
+}
+
+func (r *Readarr) SetIndexOptions(options IndexOptions) {
+	r.index.setOptions(options)
+}
+
+func (r *Readarr) SetRemovalPolicy(policy RemovalPolicy) {
+	r.policy.merge(policy)
+}
+
+func (r *Readarr) HandleEvent(ctx context.Context, event string, vars EventVars) bool {
+	switch event {
+	case "Test":
+		log.Debug("Handling Test event")
+		if !r.testApi() {
+			return false
+		}
+		return r.buildIndex()
+	case "Grab":
+		authorIdString := vars["readarr_author_id"]
+		downloadId := vars["readarr_download_id"]
+		authorName := vars["readarr_author_name"]
+		log.WithFields(log.Fields{
+			"readarr_author_id":   authorIdString,
+			"readarr_download_id": downloadId,
+			"readarr_author_name": authorName,
+		}).Debug("Handling Grab event")
+		authorId, err := strconv.Atoi(authorIdString)
+		if err != nil {
+			log.WithError(err).Error("Failed to convert readarr_author_id to int")
+			return false
+		}
+		if isValidTorrentHash(downloadId) {
+			r.files.updateIndexFile(authorId, downloadId)
+		}
+	case "Download":
+		authorIdString := vars["readarr_author_id"]
+		log.WithFields(log.Fields{
+			"readarr_author_id": authorIdString,
+		}).Debug("Handling Download event")
+		authorId, err := strconv.Atoi(authorIdString)
+		if err != nil {
+			log.WithError(err).Error("Failed to convert readarr_author_id to int")
+			return false
+		}
+		r.journal.record(r.index.name, event, authorId, r.files.removeOutdatedTorrents(ctx, authorId, nil))
+	case "BookFileDelete":
+		authorIdString := vars["readarr_author_id"]
+		deletedBookIdString := vars["readarr_book_id"]
+		log.WithFields(log.Fields{
+			"readarr_author_id": authorIdString,
+			"readarr_book_id":   deletedBookIdString,
+		}).Debug("Handling BookFileDelete event")
+		authorId, err := strconv.Atoi(authorIdString)
+		if err != nil {
+			log.WithError(err).Error("Failed to convert readarr_author_id to int")
+			return false
+		}
+		deletedBookId, err := strconv.Atoi(deletedBookIdString)
+		if err != nil {
+			log.WithError(err).Error("Failed to convert readarr_book_id to int")
+			return false
+		}
+		r.journal.record(r.index.name, event, authorId, r.files.removeOutdatedTorrents(ctx, authorId, &deletedBookId))
+	case "BookDelete":
+		authorIdString := vars["readarr_author_id"]
+		bookId := vars["readarr_book_id"]
+		log.WithFields(log.Fields{
+			"readarr_author_id": authorIdString,
+			"readarr_book_id":   bookId,
+		}).Debug("Handling BookDelete event")
+		authorId, err := strconv.Atoi(authorIdString)
+		if err != nil {
+			log.WithError(err).Error("Failed to convert readarr_author_id to int")
+			return false
+		}
+		r.journal.record(r.index.name, event, authorId, r.files.removeUnreferencedDownloads(ctx, authorId))
+	case "AuthorDelete":
+		authorIdString := vars["readarr_author_id"]
+		log.WithFields(log.Fields{
+			"readarr_author_id": authorIdString,
+		}).Debug("Handling AuthorDelete event")
+		authorId, err := strconv.Atoi(authorIdString)
+		if err != nil {
+			log.WithError(err).Error("Failed to convert readarr_author_id to int")
+			return false
+		}
+		if !r.policy.removesTorrents(vars["readarr_author_deletedfiles"]) {
+			r.journal.record(r.index.name, event, authorId, r.index.keepDownloads(readarrIndexFileName(authorId)))
+			break
+		}
+		r.journal.record(r.index.name, event, authorId, r.files.removeAllDownloads(ctx, authorId))
+	default:
+		log.WithFields(log.Fields{"Event": event}).Debug("Ignoring Readarr event type")
+	}
+	return true
+}
+
+// Reconciles the index and removes outdated torrents of every author
+func (r *Readarr) Sweep(ctx context.Context, locker sync.Locker) bool {
+	if !r.buildIndex() {
+		return false
+	}
+	authorIds, err := r.getAuthorIds()
+	if err != nil {
+		return false
+	}
+	return r.index.sweep(ctx, r.journal, locker, r.torrentClient, authorIds, func(authorId int) removal {
+		return r.files.outdatedTorrents(authorId, nil)
+	})
+}
+
+func (r *Readarr) testApi() bool {
+	log.Info("Testing Readarr accessibility")
+	var apiResponse ReadarrApiResponse
+	_, err := r.restClient.R().SetResult(&apiResponse).Get("api")
+	if err != nil {
+		log.WithError(err).Error("Couldn't connect to Readarr API")
+		return false
+	}
+	log.WithFields(log.Fields{
+		"Current API Version": apiResponse.Current,
+	}).Info("Succesfully connected to Readarr")
+	return true
+}
+
+func (r *Readarr) getAuthorIds() ([]int, error) {
+	var authors []ReadarrAuthorResponse
+	response, err := r.restClient.R().SetResult(&authors).Get("api/v1/author")
+	if err == nil && response.IsError() {
+		err = errors.New("unexpected Readarr response status " + response.Status())
+	}
+	if err != nil {
+		log.WithError(err).Error("Error making request")
+		return []int{}, err
+	}
+	authorIds := make([]int, len(authors))
+	for i, author := range authors {
+		authorIds[i] = author.Id
+	}
+	log.WithFields(log.Fields{
+		"Author Ids": authorIds,
+	}).Info()
+	return authorIds, nil
+}
+
+func (r *Readarr) getAuthorHistory(authorId int) ([]mediaFileHistory, error) {
+	params := map[string]string{
+		"authorId":      strconv.Itoa(authorId),
+		"includeAuthor": "false",
+		"includeBook":   "false",
+	}
+	var authorHistory []ReadarrAuthorHistoryResponse
+	response, err := r.restClient.R().SetQueryParams(params).SetResult(&authorHistory).Get("api/v1/history/author")
+	if err == nil && response.IsError() {
+		err = errors.New("unexpected Readarr response status " + response.Status())
+	}
+	if err != nil {
+		log.WithError(err).Error("Error making request")
+		return []mediaFileHistory{}, err
+	}
+	bookHistory := make([]mediaFileHistory, len(authorHistory))
+	for i, history := range authorHistory {
+		bookHistory[i] = mediaFileHistory{FileId: history.BookId, DownloadId: history.DownloadId, Date: history.Date, EventType: history.EventType, Data: history.Data}
+	}
+	return bookHistory, nil
+}
+
+func (r *Readarr) buildIndex() bool {
+	log.Info("Reconciling readarr author index...")
+	return r.index.reconcile(indexSource{
+		itemIds:        r.getAuthorIds,
+		changedItemIds: r.getChangedAuthorIds,
+		key:            readarrIndexFileName,
+		hashes:         r.files.getDeduplicatedDownloadIds,
+	})
+}
+
+// Returns ids of authors with history entries since the given time
+func (r *Readarr) getChangedAuthorIds(since time.Time) ([]int, error) {
+	params := map[string]string{
+		"date":          since.UTC().Format(time.RFC3339),
+		"includeAuthor": "false",
+		"includeBook":   "false",
+	}
+	var history []ReadarrHistorySinceResponse
+	response, err := r.restClient.R().SetQueryParams(params).SetResult(&history).Get("api/v1/history/since")
+	if err == nil && response.IsError() {
+		err = errors.New("unexpected Readarr response status " + response.Status())
+	}
+	if err != nil {
+		log.WithError(err).Error("Error making request")
+		return nil, err
+	}
+	seen := make(map[int]struct{})
+	var authorIds []int
+	for _, entry := range history {
+		if _, ok := seen[entry.AuthorId]; !ok {
+			seen[entry.AuthorId] = struct{}{}
+			authorIds = append(authorIds, entry.AuthorId)
+		}
+	}
+	return authorIds, nil
+}
+
+// Returns hashes referenced by the index and the history of existing authors
+func (r *Readarr) ReferencedHashes() (map[string]struct{}, error) {
+	authorIds, err := r.getAuthorIds()
+	if err != nil {
+		return nil, err
+	}
+	return r.files.referencedHashes(authorIds)
+}
+
+func readarrIndexFileName(authorId int) string {
+	return "author_" + strconv.Itoa(authorId)
+}
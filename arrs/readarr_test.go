@@ -0,0 +1,55 @@
+package arrs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/h2non/gock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestReadarrBookFileDelete(t *testing.T) {
+	defer gock.Off()
+
+	deletedBookHash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+
+	mockTorrentClient := &MockTorrentClient{}
+	// Assert that the torrent of the deleted book file is removed while the other book is kept
+	mockTorrentClient.On("RemoveTorrents", []string{deletedBookHash}).Return(nil)
+
+	testUrl := "http://localhost"
+
+	readarr := NewReadarr(t.TempDir(), "audiobooks", testUrl, "testtoken", mockTorrentClient)
+	gock.InterceptClient(readarr.restClient.GetClient())
+
+	gock.New(testUrl).
+		Get("/api/v1/history/author").
+		MatchParam("authorId", "3").
+		Reply(200).
+		JSON(`[
+			{"bookId": 30, "date": "2025-02-01T10:00:00Z", "eventType": "bookFileImported", "downloadId": "` + deletedBookHash + `"},
+			{"bookId": 31, "date": "2025-02-02T10:00:00Z", "eventType": "bookFileImported", "downloadId": "BBBBB4F4132C4AC7031F5692F36AC77A2ECBCCBB"}
+		]`)
+
+	assert.True(t, readarr.HandleEvent(context.Background(), "BookFileDelete", EventVars{"readarr_author_id": "3", "readarr_book_id": "30"}))
+
+	assert.True(t, gock.IsDone())
+	mock.AssertExpectationsForObjects(t, mockTorrentClient)
+}
+
+func TestReadarrInstancesSharingAppDir(t *testing.T) {
+	appDir := t.TempDir()
+	ebookHash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+	audiobookHash := "BBBBB4F4132C4AC7031F5692F36AC77A2ECBCCBB"
+
+	ebooks := NewReadarr(appDir, "ebooks", "http://localhost", "testtoken", nil)
+	audiobooks := NewReadarr(appDir, "audiobooks", "http://localhost", "testtoken", nil)
+	// Author ids of both instances collide, their index files mustn't
+	ebooks.index.saveIndexFile(readarrIndexFileName(3), IndexFile{Hashes: []string{ebookHash}})
+	audiobooks.index.saveIndexFile(readarrIndexFileName(3), IndexFile{Hashes: []string{audiobookHash}})
+
+	assert.Equal(t, IndexFile{Hashes: []string{ebookHash}}, ebooks.index.readIndexFile(readarrIndexFileName(3)))
+	assert.Equal(t, IndexFile{Hashes: []string{audiobookHash}}, audiobooks.index.readIndexFile(readarrIndexFileName(3)))
+	assert.Equal(t, "readarr_ebooks", ebooks.index.name)
+}
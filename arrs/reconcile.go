@@ -0,0 +1,277 @@
+package arrs
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bucket of the index database keeping the time of the last reconcile of every index
+const watermarksBucket = "watermarks"
+
+// Progress of history fetching is logged every that many items
+const indexProgressInterval = 100
+
+// Limits history requests made while reconciling an index
+type IndexOptions struct {
+	// Items whose history is fetched in parallel
+	Workers int
+	// Zero doesn't limit the request rate
+	RequestsPerSecond float64
+	// Directory of the index database, *arrs sharing it see each other's torrent references.
+	// Empty keeps the database in the .index directory next to the binary.
+	Dir string
+}
+
+var defaultIndexOptions = IndexOptions{Workers: 4}
+
+// Item whose history has to be fetched
+type indexFetch struct {
+	itemId  int
+	key     string
+	indexed []string
+}
+
+// History dates come from the *arr clock, which may run behind ours
+const reconcileOverlap = 10 * time.Minute
+
+// Items of an *arr and their torrent hashes the index is reconciled against
+type indexSource struct {
+	itemIds func() ([]int, error)
+	// Items with history entries since the given time
+	changedItemIds func(since time.Time) ([]int, error)
+	key            func(itemId int) string
+	// Hashes from the item history merged with the already indexed ones
+	hashes func(itemId int, indexed []string) ([]string, error)
+}
+
+// Brings the index up to date without dropping it: new items are indexed, index files of removed items are deleted
+// and only items with history changed since the previous reconcile are refreshed. Every item is refreshed on the first run.
+func (i *Index) reconcile(source indexSource) bool {
+	started := time.Now()
+	itemIds, err := source.itemIds()
+	if err != nil {
+		return false
+	}
+	watermark, found, err := i.watermark()
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"Index": i.name,
+		}).Error("Error reading index watermark")
+		return false
+	}
+	var changed map[int]struct{}
+	if found {
+		changedItemIds, err := source.changedItemIds(watermark.Add(-reconcileOverlap))
+		if err != nil {
+			return false
+		}
+		changed = make(map[int]struct{}, len(changedItemIds))
+		for _, itemId := range changedItemIds {
+			changed[itemId] = struct{}{}
+		}
+	}
+
+	indexed := make(map[string]IndexFile)
+	err = i.ForEach(func(key string, indexFile IndexFile) error {
+		indexed[key] = indexFile
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"Index": i.name,
+		}).Error("Error reading index")
+		return false
+	}
+
+	var added, refreshed int
+	var fetches []indexFetch
+	current := make(map[string]struct{}, len(itemIds))
+	for _, itemId := range itemIds {
+		key := source.key(itemId)
+		current[key] = struct{}{}
+		indexFile, ok := indexed[key]
+		_, itemChanged := changed[itemId]
+		switch {
+		case !ok:
+			added++
+		case !found || itemChanged:
+			refreshed++
+		default:
+			continue
+		}
+		fetches = append(fetches, indexFetch{itemId: itemId, key: key, indexed: indexFile.Hashes})
+	}
+	updates, failed := i.fetchHashes(fetches, source.hashes)
+	// Items whose history couldn't be fetched have to be refreshed by the next reconcile
+	reconciled := &started
+	if failed > 0 {
+		log.WithFields(log.Fields{
+			"Index": i.name,
+			"Items": failed,
+		}).Warn("Couldn't fetch the history of some items, keeping the index watermark")
+		reconciled = nil
+	}
+	var stale []string
+	for key := range indexed {
+		if _, ok := current[key]; !ok {
+			stale = append(stale, key)
+		}
+	}
+	// An empty library is more likely a misconfigured *arr than every item being deleted
+	if len(itemIds) == 0 && len(stale) > 0 {
+		log.WithFields(log.Fields{
+			"Index":       i.name,
+			"Index Files": len(stale),
+		}).Warn("No items returned, keeping index files")
+		stale = nil
+	}
+
+	defer i.lock()()
+	if err := i.applyReconcile(updates, stale, reconciled); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"Index": i.name,
+		}).Error("Error reconciling index")
+		return false
+	}
+	log.WithFields(log.Fields{
+		"Index":     i.name,
+		"Items":     len(itemIds),
+		"Added":     added,
+		"Refreshed": refreshed,
+		"Removed":   len(stale),
+		"Failed":    failed,
+		"Unchanged": len(itemIds) - added - refreshed,
+	}).Info("Index reconciled")
+	return true
+}
+
+// Writes reconciled index files in a single transaction, keeping hashes indexed by events in the meantime.
+// A nil watermark keeps the previous one.
+func (i *Index) applyReconcile(updates map[string]IndexFile, stale []string, watermark *time.Time) error {
+	return i.update(func(bucket *bolt.Bucket) error {
+		for key, indexFile := range updates {
+			if value := bucket.Get([]byte(key)); value != nil {
+				var current IndexFile
+				if err := json.Unmarshal(value, &current); err != nil {
+					return err
+				}
+				indexFile.Hashes = mergeHashes(indexFile.Hashes, current.Hashes)
+			}
+			if err := i.putIndexFile(bucket, key, indexFile); err != nil {
+				return err
+			}
+		}
+		for _, key := range stale {
+			log.WithFields(log.Fields{
+				"Index": i.name,
+				"Key":   key,
+			}).Info("Removing index file of an item which no longer exists")
+			if err := i.updateReferences(bucket, key, nil); err != nil {
+				return err
+			}
+			if err := bucket.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		if watermark == nil {
+			return nil
+		}
+		watermarks, err := bucket.Tx().CreateBucketIfNotExists([]byte(watermarksBucket))
+		if err != nil {
+			return err
+		}
+		value, err := watermark.MarshalText()
+		if err != nil {
+			return err
+		}
+		return watermarks.Put([]byte(i.name), value)
+	})
+}
+
+func (i *Index) watermark() (time.Time, bool, error) {
+	var watermark time.Time
+	var found bool
+	err := i.view(func(bucket *bolt.Bucket) error {
+		watermarks := bucket.Tx().Bucket([]byte(watermarksBucket))
+		if watermarks == nil {
+			return nil
+		}
+		value := watermarks.Get([]byte(i.name))
+		if value == nil {
+			return nil
+		}
+		found = true
+		return watermark.UnmarshalText(value)
+	})
+	return watermark, found, err
+}
+
+// Appends hashes missing from the first list, keeping its order
+func mergeHashes(hashes []string, others []string) []string {
+	known := upperHashes(hashes)
+	for _, hash := range others {
+		if _, ok := known[strings.ToUpper(hash)]; !ok {
+			known[strings.ToUpper(hash)] = struct{}{}
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes
+}
+
+// Fetches hashes of the items with a bounded worker pool, large libraries need thousands of history requests.
+// Items whose history couldn't be fetched are left out and counted as failed.
+func (i *Index) fetchHashes(fetches []indexFetch, hashes func(itemId int, indexed []string) ([]string, error)) (map[string]IndexFile, int) {
+	indexFiles := make(map[string]IndexFile, len(fetches))
+	if len(fetches) == 0 {
+		return indexFiles, 0
+	}
+	var throttle <-chan time.Time
+	if i.options.RequestsPerSecond > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / i.options.RequestsPerSecond))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+
+	queue := make(chan indexFetch)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	var fetched, failed int
+	for range max(i.options.Workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fetch := range queue {
+				if throttle != nil {
+					<-throttle
+				}
+				itemHashes, err := hashes(fetch.itemId, fetch.indexed)
+				mutex.Lock()
+				fetched++
+				if err != nil {
+					failed++
+				} else {
+					indexFiles[fetch.key] = IndexFile{Hashes: itemHashes}
+				}
+				if fetched%indexProgressInterval == 0 {
+					log.WithFields(log.Fields{
+						"Index":   i.name,
+						"Fetched": fetched,
+						"Total":   len(fetches),
+					}).Info("Fetching history")
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	for _, fetch := range fetches {
+		queue <- fetch
+	}
+	close(queue)
+	wg.Wait()
+	return indexFiles, failed
+}
@@ -0,0 +1,173 @@
+package arrs
+
+import (
+	"encoding/json"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bucket of the index database mapping uppercased torrent hashes to index files referencing them
+const referencesBucket = "references"
+
+// Index file of a series, movie, artist or author referencing a torrent
+type Reference struct {
+	Index string `json:"index"`
+	Key   string `json:"key"`
+}
+
+func (i *Index) References(hash string) ([]Reference, error) {
+	var references []Reference
+	err := i.view(func(bucket *bolt.Bucket) error {
+		var err error
+		references, err = readReferences(bucket.Tx().Bucket([]byte(referencesBucket)), hash)
+		return err
+	})
+	return references, err
+}
+
+// Returns hashes of the index file no other item references.
+// Multi-show packs and movie collections are grabbed once but imported into several items.
+func (i *Index) unsharedHashes(key string, hashes []string) []string {
+	var unshared []string
+	for _, hash := range hashes {
+		references, err := i.References(hash)
+		if err != nil {
+			// Keeping a torrent is recoverable, removing a shared one isn't
+			log.WithError(err).WithFields(log.Fields{
+				"Hash": hash,
+			}).Error("Error reading torrent references, keeping the torrent")
+			continue
+		}
+		var others []string
+		for _, reference := range references {
+			if reference.Index != i.name || reference.Key != key {
+				others = append(others, reference.Index+"/"+reference.Key)
+			}
+		}
+		if len(others) > 0 {
+			log.WithFields(log.Fields{
+				"Hash":          hash,
+				"Referenced By": others,
+			}).Info("Keeping torrent shared with other items")
+			continue
+		}
+		unshared = append(unshared, hash)
+	}
+	return unshared
+}
+
+// Replaces references of the index file with the given hashes, no hashes drop all of them
+func (i *Index) updateReferences(bucket *bolt.Bucket, key string, hashes []string) error {
+	references := bucket.Tx().Bucket([]byte(referencesBucket))
+	var previous IndexFile
+	if value := bucket.Get([]byte(key)); value != nil {
+		if err := json.Unmarshal(value, &previous); err != nil {
+			return err
+		}
+	}
+	reference := Reference{Index: i.name, Key: key}
+	current := upperHashes(hashes)
+	for hash := range upperHashes(previous.Hashes) {
+		if _, ok := current[hash]; !ok {
+			if err := removeReference(references, hash, reference); err != nil {
+				return err
+			}
+		}
+	}
+	for hash := range current {
+		if err := addReference(references, hash, reference); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Creates the references bucket, filling it from indexes written before it existed
+func ensureReferences(tx *bolt.Tx) error {
+	if tx.Bucket([]byte(referencesBucket)) != nil {
+		return nil
+	}
+	references, err := tx.CreateBucket([]byte(referencesBucket))
+	if err != nil {
+		return err
+	}
+	return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+		if string(name) == referencesBucket || string(name) == watermarksBucket {
+			return nil
+		}
+		return bucket.ForEach(func(key, value []byte) error {
+			var indexFile IndexFile
+			if err := json.Unmarshal(value, &indexFile); err != nil {
+				log.WithError(err).WithFields(log.Fields{
+					"Index": string(name),
+					"Key":   string(key),
+				}).Warn("Skipping malformed index file")
+				return nil
+			}
+			for hash := range upperHashes(indexFile.Hashes) {
+				if err := addReference(references, hash, Reference{Index: string(name), Key: string(key)}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+func readReferences(references *bolt.Bucket, hash string) ([]Reference, error) {
+	value := references.Get([]byte(strings.ToUpper(hash)))
+	if value == nil {
+		return nil, nil
+	}
+	var hashReferences []Reference
+	err := json.Unmarshal(value, &hashReferences)
+	return hashReferences, err
+}
+
+func writeReferences(references *bolt.Bucket, hash string, hashReferences []Reference) error {
+	if len(hashReferences) == 0 {
+		return references.Delete([]byte(hash))
+	}
+	jsonBytes, err := json.Marshal(hashReferences)
+	if err != nil {
+		return err
+	}
+	return references.Put([]byte(hash), jsonBytes)
+}
+
+func addReference(references *bolt.Bucket, hash string, reference Reference) error {
+	hashReferences, err := readReferences(references, hash)
+	if err != nil {
+		return err
+	}
+	for _, existing := range hashReferences {
+		if existing == reference {
+			return nil
+		}
+	}
+	return writeReferences(references, hash, append(hashReferences, reference))
+}
+
+func removeReference(references *bolt.Bucket, hash string, reference Reference) error {
+	hashReferences, err := readReferences(references, hash)
+	if err != nil {
+		return err
+	}
+	remaining := hashReferences[:0]
+	for _, existing := range hashReferences {
+		if existing != reference {
+			remaining = append(remaining, existing)
+		}
+	}
+	return writeReferences(references, hash, remaining)
+}
+
+func upperHashes(hashes []string) map[string]struct{} {
+	upper := make(map[string]struct{}, len(hashes))
+	for _, hash := range hashes {
+		upper[strings.ToUpper(hash)] = struct{}{}
+	}
+	return upper
+}
@@ -0,0 +1,92 @@
+package arrs
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Hashes removed from a single index file according to the journal
+type Restore struct {
+	Arr    string
+	ItemId int
+	Hashes []string
+}
+
+// Index file names by *arr, instance suffixes of the index name are ignored
+var indexFileNames = map[string]func(itemId int) string{
+	"sonarr":  sonarrIndexFileName,
+	"radarr":  radarrIndexFileName,
+	"lidarr":  lidarrIndexFileName,
+	"readarr": readarrIndexFileName,
+}
+
+// Finds journal entries which removed the hash
+func (j *Journal) RestoresForHash(hash string) ([]Restore, error) {
+	entries, err := j.Entries(hash)
+	if err != nil {
+		return nil, err
+	}
+	var restores []Restore
+	for _, entry := range entries {
+		for _, entryHash := range entry.Hashes {
+			if strings.EqualFold(entryHash, hash) {
+				restores = append(restores, Restore{Arr: entry.Arr, ItemId: entry.ItemId, Hashes: []string{entryHash}})
+				break
+			}
+		}
+	}
+	return restores, nil
+}
+
+// Finds journal entries which removed torrents of a series, movie, artist or author
+func (j *Journal) RestoresForItem(arr string, itemId int) ([]Restore, error) {
+	entries, err := j.Entries("")
+	if err != nil {
+		return nil, err
+	}
+	var restores []Restore
+	for _, entry := range entries {
+		if entry.Arr == arr && entry.ItemId == itemId && len(entry.Hashes) > 0 {
+			restores = append(restores, Restore{Arr: entry.Arr, ItemId: entry.ItemId, Hashes: entry.Hashes})
+		}
+	}
+	return restores, nil
+}
+
+// Puts restored hashes back into their index files
+func RestoreIndex(appDir string, restores []Restore) bool {
+	success := true
+	for _, restore := range restores {
+		indexFileName, ok := indexFileNames[strings.SplitN(restore.Arr, "_", 2)[0]]
+		if !ok {
+			log.WithFields(log.Fields{
+				"Arr": restore.Arr,
+			}).Error("Unknown index")
+			success = false
+			continue
+		}
+		index := NewIndex(restore.Arr, appDir)
+		if !index.addHashes(indexFileName(restore.ItemId), restore.Hashes) {
+			success = false
+		}
+	}
+	return success
+}
+
+// Merges hashes into the index file, creating it when it was removed
+func (i *Index) addHashes(name string, hashes []string) bool {
+	defer i.lock()()
+	indexFile := i.readExistingIndexFile(name)
+	known := make(map[string]struct{}, len(indexFile.Hashes))
+	for _, hash := range indexFile.Hashes {
+		known[strings.ToUpper(hash)] = struct{}{}
+	}
+	for _, hash := range hashes {
+		if _, ok := known[strings.ToUpper(hash)]; !ok {
+			known[strings.ToUpper(hash)] = struct{}{}
+			indexFile.Hashes = append(indexFile.Hashes, hash)
+		}
+	}
+	return i.saveIndexFile(name, indexFile)
+}
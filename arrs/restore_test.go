@@ -0,0 +1,49 @@
+package arrs
+
+import (
+	"arrcoon/clients"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestoreSeriesIndex(t *testing.T) {
+	appDir := t.TempDir()
+	removedHash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+	otherHash := "BBBBB4F4132C4AC7031F5692F36AC77A2ECBCCBB"
+
+	journal := NewJournal(appDir)
+	journal.record("sonarr", "SeriesDelete", 85, removal{
+		hashes:  []string{removedHash, otherHash},
+		results: clients.RemoveResults{{Hash: removedHash, Status: clients.StatusQuarantined}, {Hash: otherHash, Status: clients.StatusQuarantined}},
+	})
+	journal.record("radarr", "MovieDelete", 85, removal{
+		hashes: []string{otherHash},
+	})
+
+	restores, err := journal.RestoresForItem("sonarr", 85)
+	assert.NoError(t, err)
+	assert.Equal(t, []Restore{{Arr: "sonarr", ItemId: 85, Hashes: []string{removedHash, otherHash}}}, restores)
+
+	assert.True(t, RestoreIndex(appDir, restores))
+	index := NewIndex("sonarr", appDir)
+	assert.Equal(t, IndexFile{Hashes: []string{removedHash, otherHash}}, index.readIndexFile(sonarrIndexFileName(85)))
+}
+
+func TestRestoreHashMergesIndex(t *testing.T) {
+	appDir := t.TempDir()
+	removedHash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+	keptHash := "BBBBB4F4132C4AC7031F5692F36AC77A2ECBCCBB"
+
+	index := NewIndex("readarr_audiobooks", appDir)
+	index.saveIndexFile(readarrIndexFileName(3), IndexFile{Hashes: []string{keptHash}})
+	journal := NewJournal(appDir)
+	journal.record("readarr_audiobooks", "BookDelete", 3, removal{hashes: []string{removedHash}})
+
+	restores, err := journal.RestoresForHash("aaaaad29f161e9dd7b2bc43a53d5114760c764aa")
+	assert.NoError(t, err)
+	assert.Equal(t, []Restore{{Arr: "readarr_audiobooks", ItemId: 3, Hashes: []string{removedHash}}}, restores)
+
+	assert.True(t, RestoreIndex(appDir, restores))
+	assert.Equal(t, IndexFile{Hashes: []string{keptHash, removedHash}}, index.readIndexFile(readarrIndexFileName(3)))
+}
@@ -1,9 +1,27 @@
 package arrs
 
-import "regexp"
+import (
+	"arrcoon/clients"
+	"regexp"
+)
 
 const AUTH_HEADER = "X-Api-Key"
 
+// Download client details attached to *arr history entries
+type HistoryData struct {
+	DownloadClient     string `json:"downloadClient"`
+	DownloadClientName string `json:"downloadClientName"`
+	// Delete reason of file deleted entries
+	Reason string `json:"reason"`
+}
+
+func (hd HistoryData) clientName() string {
+	if hd.DownloadClientName != "" {
+		return hd.DownloadClientName
+	}
+	return hd.DownloadClient
+}
+
 // function that checks if string a valid torrent hash value
 func isValidTorrentHash(hash string) bool {
 	// SHA-1 hash (40-character hexadecimal)
@@ -16,3 +34,12 @@ func isValidTorrentHash(hash string) bool {
 
 	return hexMatch || base32Match
 }
+
+// Tells multi-client setups which *arr download client a hash was grabbed with
+func routeHash(torrentClient clients.TorrentClient, hash string, data HistoryData) {
+	router, ok := torrentClient.(clients.HashRouter)
+	if !ok || !isValidTorrentHash(hash) {
+		return
+	}
+	router.RouteHash(hash, data.clientName())
+}
@@ -2,10 +2,12 @@ package arrs
 
 import (
 	"arrcoon/clients"
-	"os"
+	"context"
+	"errors"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-resty/resty/v2"
@@ -17,6 +19,8 @@ type Sonarr struct {
 	torrentClient clients.TorrentClient
 	restClient    *resty.Client
 	index         Index
+	journal       *Journal
+	policy        RemovalPolicy
 }
 
 type SonarrSeriesResponse struct {
@@ -27,11 +31,16 @@ type SonarrApiResponse struct {
 	Current string `json:"current"`
 }
 
+type SonarrHistorySinceResponse struct {
+	SeriesId int `json:"seriesId"`
+}
+
 type SonarrSeriesEpisodeHistoryResponse struct {
-	EpisodeId  int       `json:"episodeId"`
-	DownloadId string    `json:"downloadId"`
-	Date       time.Time `json:"date"`
-	EventType  string    `json:"eventType"`
+	EpisodeId  int         `json:"episodeId"`
+	DownloadId string      `json:"downloadId"`
+	Date       time.Time   `json:"date"`
+	EventType  string      `json:"eventType"`
+	Data       HistoryData `json:"data"`
 }
 
 func NewSonarr(appDir string, host string, token string, torrentClient clients.TorrentClient) *Sonarr {
@@ -40,21 +49,31 @@ func NewSonarr(appDir string, host string, token string, torrentClient clients.T
 		torrentClient: torrentClient,
 		restClient:    resty.New().SetBaseURL(host).SetHeader(AUTH_HEADER, token),
 		index:         *NewIndex("sonarr", appDir),
+		journal:       NewJournal(appDir),
+		policy:        defaultRemovalPolicy,
 	}
 }
-func (s *Sonarr) HandleEvent(event string) {
+
+func (s *Sonarr) SetIndexOptions(options IndexOptions) {
+	s.index.setOptions(options)
+}
+
+func (s *Sonarr) SetRemovalPolicy(policy RemovalPolicy) {
+	s.policy.merge(policy)
+}
+
+func (s *Sonarr) HandleEvent(ctx context.Context, event string, vars EventVars) bool {
 	switch event {
 	case "Test":
 		log.Debug("Handling Test event")
 		if !s.testApi() {
-			os.Exit(1)
+			return false
 		}
-		s.index.dropIndex()
-		s.buildIndex()
+		return s.buildIndex()
 	case "Grab":
-		seriesIdString := os.Getenv("sonarr_series_id")
-		downloadId := os.Getenv("sonarr_download_id")
-		seriesTitle := os.Getenv("sonarr_series_title")
+		seriesIdString := vars["sonarr_series_id"]
+		downloadId := vars["sonarr_download_id"]
+		seriesTitle := vars["sonarr_series_title"]
 		log.WithFields(log.Fields{
 			"sonarr_series_id":    seriesIdString,
 			"sonarr_download_id":  downloadId,
@@ -63,13 +82,13 @@ func (s *Sonarr) HandleEvent(event string) {
 		seriesId, err := strconv.Atoi(seriesIdString)
 		if err != nil {
 			log.WithError(err).Error("Failed to convert grabbedSeriesId to int")
-			return
+			return false
 		}
 		if isValidTorrentHash(downloadId) {
 			s.updateIndexFile(seriesId, downloadId)
 		}
 	case "Download":
-		seriesIdString := os.Getenv("sonarr_series_id")
+		seriesIdString := vars["sonarr_series_id"]
 		// Log the event
 		log.WithFields(log.Fields{
 			"sonarr_series_id": seriesIdString,
@@ -77,45 +96,80 @@ func (s *Sonarr) HandleEvent(event string) {
 		seriesId, err := strconv.Atoi(seriesIdString)
 		if err != nil {
 			log.WithError(err).Error("Failed to convert sonarr_series_id to int")
-			return
+			return false
 		}
-		s.removeOutdatedTorrents(seriesId, nil)
+		unscheduleRemovals(s.torrentClient, vars["sonarr_download_id"])
+		s.journal.record(s.index.name, event, seriesId, s.removeOutdatedTorrents(ctx, seriesId, nil))
 	case "EpisodeFileDelete":
-		seriesIdString := os.Getenv("sonarr_series_id")
-		deletedEpisodeIdString := os.Getenv("sonarr_episodefile_id")
-		deletedEpisodeIdsString := os.Getenv("sonarr_episodefile_episodeids")
+		seriesIdString := vars["sonarr_series_id"]
+		deletedEpisodeIdString := vars["sonarr_episodefile_id"]
+		deletedEpisodeIdsString := vars["sonarr_episodefile_episodeids"]
+		deleteReason := vars["sonarr_episodefile_deletereason"]
 		// Log the event
 		log.WithFields(log.Fields{
-			"sonarr_series_id":              seriesIdString,
-			"sonarr_episodefile_id":         deletedEpisodeIdString,
-			"sonarr_episodefile_episodeids": deletedEpisodeIdsString,
+			"sonarr_series_id":                seriesIdString,
+			"sonarr_episodefile_id":           deletedEpisodeIdString,
+			"sonarr_episodefile_episodeids":   deletedEpisodeIdsString,
+			"sonarr_episodefile_deletereason": deleteReason,
 		}).Debug("Handling EpisodeFileDelete event")
 		seriesId, err := strconv.Atoi(seriesIdString)
 		if err != nil {
 			log.WithError(err).Error("Failed to convert sonarr_series_id to int")
-			return
+			return false
 		}
 		episodeIdsParts := strings.Split(deletedEpisodeIdsString, ",")
 		deletedEpisodeId, err := strconv.Atoi(strings.TrimSpace(episodeIdsParts[0]))
 		if err != nil {
 			log.WithError(err).Error("Failed to convert sonarr_episodefile_id to int")
-			return
+			return false
+		}
+		if !s.policy.releasesTorrent(deleteReason, time.Now()) {
+			log.WithFields(log.Fields{
+				"Series Id":     seriesId,
+				"Delete Reason": deleteReason,
+			}).Info("Keeping torrents of the deleted episode file for now")
+			kept := s.keptTorrents(seriesId, deletedEpisodeId)
+			if gracePeriod, ok := s.policy.gracePeriod(deleteReason); ok {
+				kept.results = scheduleRemovals(s.torrentClient, kept.kept, time.Now().Add(gracePeriod))
+			}
+			s.journal.record(s.index.name, event, seriesId, kept)
+			break
 		}
-		s.removeOutdatedTorrents(seriesId, &deletedEpisodeId)
+		s.journal.record(s.index.name, event, seriesId, s.removeOutdatedTorrents(ctx, seriesId, &deletedEpisodeId))
 	case "SeriesDelete":
-		removedSeriesId := os.Getenv("sonarr_series_id")
+		removedSeriesId := vars["sonarr_series_id"]
 		seriesId, err := strconv.Atoi(removedSeriesId)
 		if err != nil {
 			log.WithError(err).Error("Failed to convert sonarr_series_id to int")
-			return
+			return false
 		}
 		log.WithFields(log.Fields{
 			"sonarr_series_id": removedSeriesId,
 		}).Debug("Handling SeriesDelete event")
-		s.removeAllDownloads(seriesId)
+		if !s.policy.removesTorrents(vars["sonarr_series_deletedfiles"]) {
+			s.journal.record(s.index.name, event, seriesId, s.index.keepDownloads(sonarrIndexFileName(seriesId)))
+			break
+		}
+		s.journal.record(s.index.name, event, seriesId, s.removeAllDownloads(ctx, seriesId))
 	default:
 		log.WithFields(log.Fields{"Event": event}).Debug("Ignoring Sonarr event type")
 	}
+	return true
+}
+
+// Reconciles the index and removes outdated torrents of every series
+func (s *Sonarr) Sweep(ctx context.Context, locker sync.Locker) bool {
+	if !s.buildIndex() {
+		return false
+	}
+	seriesIds, err := s.getSeriesIds()
+	if err != nil {
+		return false
+	}
+	return s.index.sweep(ctx, s.journal, locker, s.torrentClient, seriesIds, func(seriesId int) removal {
+		seriesHistory, _ := s.getSeriesHistory(seriesId)
+		return s.outdatedTorrents(seriesHistory, nil)
+	})
 }
 
 func (s *Sonarr) testApi() bool {
@@ -132,15 +186,18 @@ func (s *Sonarr) testApi() bool {
 	return true
 }
 
-func (s *Sonarr) getSeriesIds() []int {
+func (s *Sonarr) getSeriesIds() ([]int, error) {
 	params := map[string]string{
 		"includeSeasonImages": "false",
 	}
 	var series []SonarrSeriesResponse
-	_, err := s.restClient.R().SetQueryParams(params).SetResult(&series).Get("api/v3/series")
+	response, err := s.restClient.R().SetQueryParams(params).SetResult(&series).Get("api/v3/series")
+	if err == nil && response.IsError() {
+		err = errors.New("unexpected Sonarr response status " + response.Status())
+	}
 	if err != nil {
 		log.WithError(err).Error("Error making request")
-		return []int{}
+		return []int{}, err
 	}
 	seriesIds := make([]int, len(series))
 	for i, series := range series {
@@ -149,16 +206,46 @@ func (s *Sonarr) getSeriesIds() []int {
 	log.WithFields(log.Fields{
 		"Series Ids": seriesIds,
 	}).Info()
-	return seriesIds
+	return seriesIds, nil
 }
 
 // Removes all torrents files which are not mapped to active episodes
-func (s *Sonarr) removeOutdatedTorrents(seriesId int, removedEpisodeId *int) {
-	seriesHistory := s.getSeriesHistory(seriesId)
+func (s *Sonarr) removeOutdatedTorrents(ctx context.Context, seriesId int, removedEpisodeId *int) removal {
+	seriesHistory, _ := s.getSeriesHistory(seriesId)
+	removal := s.outdatedTorrents(seriesHistory, removedEpisodeId)
+	removal.results = s.torrentClient.RemoveTorrents(ctx, removal.hashes)
+	return removal
+}
+
+// Torrents outdated by the deleted episode file which the removal policy keeps seeding
+func (s *Sonarr) keptTorrents(seriesId int, deletedEpisodeId int) removal {
+	seriesHistory, _ := s.getSeriesHistory(seriesId)
+	outdated := s.outdatedTorrents(seriesHistory, &deletedEpisodeId)
+	// Torrents outdated regardless of the deletion aren't kept because of it
+	previouslyOutdated := make(map[string]struct{})
+	for _, hash := range s.outdatedTorrents(seriesHistory, nil).hashes {
+		previouslyOutdated[hash] = struct{}{}
+	}
+	var kept []string
+	for _, hash := range outdated.hashes {
+		if _, ok := previouslyOutdated[hash]; !ok {
+			kept = append(kept, hash)
+		}
+	}
+	sort.Strings(kept)
+	return removal{history: outdated.history, kept: kept}
+}
 
+// Picks torrents which are not mapped to active episodes according to the series history
+func (s *Sonarr) outdatedTorrents(seriesHistory []SonarrSeriesEpisodeHistoryResponse, removedEpisodeId *int) removal {
 	// Collect all file imported history entries where torrent hash download id or where the event type is episodeFileDeleted
 	relevantSeriesHistory := make([]SonarrSeriesEpisodeHistoryResponse, 0)
 	for _, history := range seriesHistory {
+		routeHash(s.torrentClient, history.DownloadId, history.Data)
+		// Deletions kept by the policy or still in their grace period don't outdate torrents yet
+		if history.EventType == "episodeFileDeleted" && !s.policy.releasesTorrent(history.Data.Reason, history.Date) {
+			continue
+		}
 		if (isValidTorrentHash(history.DownloadId) && (history.EventType == "downloadFolderImported")) || history.EventType == "episodeFileDeleted" {
 			relevantSeriesHistory = append(relevantSeriesHistory, history)
 		}
@@ -232,26 +319,37 @@ func (s *Sonarr) removeOutdatedTorrents(seriesId int, removedEpisodeId *int) {
 		"Outdated Hash Values": oudatedHashValues,
 	}).Debug()
 
-	s.torrentClient.RemoveTorrents(oudatedHashValues)
+	journalHistory := make([]JournalHistory, len(relevantSeriesHistory))
+	for i, history := range relevantSeriesHistory {
+		journalHistory[i] = JournalHistory{Id: history.EpisodeId, DownloadId: history.DownloadId, EventType: history.EventType, Date: history.Date}
+	}
+	return removal{
+		history: journalHistory,
+		hashes:  oudatedHashValues,
+	}
 }
 
-func (s *Sonarr) getSeriesHistory(seriesId int) []SonarrSeriesEpisodeHistoryResponse {
+func (s *Sonarr) getSeriesHistory(seriesId int) ([]SonarrSeriesEpisodeHistoryResponse, error) {
 	params := map[string]string{
 		"seriesId":       strconv.Itoa(seriesId),
 		"includeSeries":  "false",
 		"includeEpisode": "false",
 	}
 	var seriesHistory []SonarrSeriesEpisodeHistoryResponse
-	_, err := s.restClient.R().SetQueryParams(params).SetResult(&seriesHistory).Get("api/v3/history/series")
+	response, err := s.restClient.R().SetQueryParams(params).SetResult(&seriesHistory).Get("api/v3/history/series")
+	if err == nil && response.IsError() {
+		err = errors.New("unexpected Sonarr response status " + response.Status())
+	}
 	if err != nil {
 		log.WithError(err).Error("Error making request")
-		return []SonarrSeriesEpisodeHistoryResponse{}
+		return []SonarrSeriesEpisodeHistoryResponse{}, err
 	}
-	return seriesHistory
+	return seriesHistory, nil
 }
 
-func (s *Sonarr) getDeduplicatedDownloadIds(seriesId int, downloadIds []string) []string {
-	seriesHistory := s.getSeriesHistory(seriesId)
+// Fails along with the history request, returning only the given download ids
+func (s *Sonarr) getDeduplicatedDownloadIds(seriesId int, downloadIds []string) ([]string, error) {
+	seriesHistory, err := s.getSeriesHistory(seriesId)
 	uniqueRequestedDownloadsMap := make(map[string]struct{})
 	var uniqueRequestedDownloadIds []string
 	for _, history := range seriesHistory {
@@ -279,43 +377,90 @@ func (s *Sonarr) getDeduplicatedDownloadIds(seriesId int, downloadIds []string)
 		"Hashes":    validTorrentHashDownloadIds,
 	}).Debug("Deduplicated download ids")
 
-	return validTorrentHashDownloadIds
+	return validTorrentHashDownloadIds, err
 }
 
-func (s *Sonarr) removeAllDownloads(seriesId int) {
+func (s *Sonarr) removeAllDownloads(ctx context.Context, seriesId int) removal {
+	defer s.index.lock()()
 	indexFile := s.index.readIndexFile(sonarrIndexFileName(seriesId))
-	if len(indexFile.Hashes) > 0 {
-		s.torrentClient.RemoveTorrents(indexFile.Hashes)
+	hashes := s.index.unsharedHashes(sonarrIndexFileName(seriesId), indexFile.Hashes)
+	if len(hashes) == 0 {
+		s.index.removeIndexFile(sonarrIndexFileName(seriesId))
+		return removal{}
+	}
+	results := s.torrentClient.RemoveTorrents(ctx, hashes)
+	// Keep hashes which failed to be removed for a later retry
+	if failed := results.Failed(); len(failed) > 0 {
+		log.WithFields(log.Fields{
+			"Hashes": failed,
+		}).Warn("Keeping index file for torrents which couldn't be removed")
+		s.index.saveIndexFile(sonarrIndexFileName(seriesId), IndexFile{Hashes: failed})
+		return removal{hashes: hashes, results: results}
 	}
 	s.index.removeIndexFile(sonarrIndexFileName(seriesId))
+	return removal{hashes: hashes, results: results}
 }
 
-func (s *Sonarr) buildIndex() {
-	log.Info("Building sonarr series index...")
-	seriesIds := s.getSeriesIds()
-	var indexedSeriesCounter int
-	for _, seriesId := range seriesIds {
-		hashes := s.getDeduplicatedDownloadIds(seriesId, nil)
-		indexFile := &IndexFile{
-			Hashes: hashes,
-		}
-		if s.index.saveIndexFile("series_"+strconv.Itoa(seriesId), *indexFile) {
-			indexedSeriesCounter++
-		} else {
-			os.Exit(1)
+func (s *Sonarr) buildIndex() bool {
+	log.Info("Reconciling sonarr series index...")
+	return s.index.reconcile(indexSource{
+		itemIds:        s.getSeriesIds,
+		changedItemIds: s.getChangedSeriesIds,
+		key:            sonarrIndexFileName,
+		hashes:         s.getDeduplicatedDownloadIds,
+	})
+}
+
+// Returns ids of series with history entries since the given time
+func (s *Sonarr) getChangedSeriesIds(since time.Time) ([]int, error) {
+	params := map[string]string{
+		"date":           since.UTC().Format(time.RFC3339),
+		"includeSeries":  "false",
+		"includeEpisode": "false",
+	}
+	var history []SonarrHistorySinceResponse
+	response, err := s.restClient.R().SetQueryParams(params).SetResult(&history).Get("api/v3/history/since")
+	if err == nil && response.IsError() {
+		err = errors.New("unexpected Sonarr response status " + response.Status())
+	}
+	if err != nil {
+		log.WithError(err).Error("Error making request")
+		return nil, err
+	}
+	seen := make(map[int]struct{})
+	var seriesIds []int
+	for _, entry := range history {
+		if _, ok := seen[entry.SeriesId]; !ok {
+			seen[entry.SeriesId] = struct{}{}
+			seriesIds = append(seriesIds, entry.SeriesId)
 		}
 	}
-	log.WithFields(log.Fields{
-		"Indexed Series": indexedSeriesCounter,
-	}).Info("Sonarr index built")
+	return seriesIds, nil
 }
 
 func (s *Sonarr) updateIndexFile(seriesId int, downloadId string) {
-	hashes := s.getDeduplicatedDownloadIds(seriesId, []string{downloadId})
-	indexFile := &IndexFile{
-		Hashes: hashes,
+	defer s.index.lock()()
+	// Hashes indexed by concurrent Grab events may not be in the history yet
+	indexFile := s.index.readExistingIndexFile(sonarrIndexFileName(seriesId))
+	hashes, _ := s.getDeduplicatedDownloadIds(seriesId, append(indexFile.Hashes, downloadId))
+	indexFile.Hashes = hashes
+	s.index.saveIndexFile(sonarrIndexFileName(seriesId), indexFile)
+}
+
+// Returns hashes referenced by the index and the history of existing series
+func (s *Sonarr) ReferencedHashes() (map[string]struct{}, error) {
+	seriesIds, err := s.getSeriesIds()
+	if err != nil {
+		return nil, err
 	}
-	s.index.saveIndexFile(sonarrIndexFileName(seriesId), *indexFile)
+	return referencedHashes(s.index, seriesIds, func(seriesId int) ([]string, error) {
+		seriesHistory, err := s.getSeriesHistory(seriesId)
+		downloadIds := make([]string, len(seriesHistory))
+		for i, history := range seriesHistory {
+			downloadIds[i] = history.DownloadId
+		}
+		return downloadIds, err
+	})
 }
 
 func sonarrIndexFileName(seriesId int) string {
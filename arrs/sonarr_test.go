@@ -1,9 +1,13 @@
 package arrs
 
 import (
+	"arrcoon/clients"
 	testutils "arrcoon/testing"
-	"os"
+	"context"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -15,8 +19,22 @@ type MockTorrentClient struct {
 	mock.Mock
 }
 
-func (m *MockTorrentClient) RemoveTorrents(hashes []string) {
-	m.Called(hashes)
+func (m *MockTorrentClient) RemoveTorrents(ctx context.Context, hashes []string) clients.RemoveResults {
+	args := m.Called(hashes)
+	results, _ := args.Get(0).(clients.RemoveResults)
+	return results
+}
+
+func (m *MockTorrentClient) ExistingTorrents(hashes []string) ([]string, error) {
+	args := m.Called(hashes)
+	existing, _ := args.Get(0).([]string)
+	return existing, args.Error(1)
+}
+
+func (m *MockTorrentClient) ListTorrents(ctx context.Context) ([]clients.TorrentContent, error) {
+	args := m.Called()
+	contents, _ := args.Get(0).([]clients.TorrentContent)
+	return contents, args.Error(1)
 }
 
 func (m *MockTorrentClient) Test() bool {
@@ -50,7 +68,7 @@ func TestPartiallyRemovedSeason(t *testing.T) {
 
 	testUrl := "http://localhost"
 
-	sonarr := NewSonarr("testdir", testUrl, "testtoken", mockTorrentClient)
+	sonarr := NewSonarr(t.TempDir(), testUrl, "testtoken", mockTorrentClient)
 	gock.InterceptClient(sonarr.restClient.GetClient())
 
 	gock.New(testUrl).
@@ -63,7 +81,7 @@ func TestPartiallyRemovedSeason(t *testing.T) {
 		Reply(200).
 		JSON(testutils.LoadJson("history_season_partially_removed"))
 
-	sonarr.removeOutdatedTorrents(85, nil)
+	sonarr.removeOutdatedTorrents(context.Background(), 85, nil)
 
 	assert.True(t, gock.IsDone())
 	mock.AssertExpectationsForObjects(t, mockTorrentClient)
@@ -78,7 +96,7 @@ func TestEntirelyRemovedSeason(t *testing.T) {
 
 	testUrl := "http://localhost"
 
-	sonarr := NewSonarr("testdir", testUrl, "testtoken", mockTorrentClient)
+	sonarr := NewSonarr(t.TempDir(), testUrl, "testtoken", mockTorrentClient)
 	gock.InterceptClient(sonarr.restClient.GetClient())
 
 	gock.New(testUrl).
@@ -91,12 +109,288 @@ func TestEntirelyRemovedSeason(t *testing.T) {
 		Reply(200).
 		JSON(testutils.LoadJson("history_season_removed"))
 
-	os.Setenv("sonarr_series_id", "85")
-	os.Setenv("sonarr_episodefile_id", "1512")
-	os.Setenv("sonarr_episodefile_episodeids", "3752")
+	sonarr.HandleEvent(context.Background(), "EpisodeFileDelete", EventVars{
+		"sonarr_series_id":              "85",
+		"sonarr_episodefile_id":         "1512",
+		"sonarr_episodefile_episodeids": "3752",
+	})
+
+	assert.True(t, gock.IsDone())
+	mock.AssertExpectationsForObjects(t, mockTorrentClient)
+}
+
+func TestSeriesDeleteKeepsFailedHashes(t *testing.T) {
+	failedHash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+	removedHash := "BBBBB4F4132C4AC7031F5692F36AC77A2ECBCCBB"
+
+	mockTorrentClient := &MockTorrentClient{}
+	mockTorrentClient.On("RemoveTorrents", []string{failedHash, removedHash}).Return(clients.RemoveResults{
+		{Hash: failedHash, Status: clients.StatusFailed},
+		{Hash: removedHash, Status: clients.StatusRemoved},
+	})
+
+	sonarr := NewSonarr(t.TempDir(), "http://localhost", "testtoken", mockTorrentClient)
+	sonarr.index.saveIndexFile(sonarrIndexFileName(85), IndexFile{Hashes: []string{failedHash, removedHash}})
+
+	assert.True(t, sonarr.HandleEvent(context.Background(), "SeriesDelete", EventVars{"sonarr_series_id": "85"}))
+
+	mock.AssertExpectationsForObjects(t, mockTorrentClient)
+	assert.Equal(t, []string{failedHash}, sonarr.index.readIndexFile(sonarrIndexFileName(85)).Hashes)
+}
+
+func TestSeriesDeleteKeepsTorrentsSharedWithOtherItems(t *testing.T) {
+	appDir := t.TempDir()
+	packHash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+	ownHash := "BBBBB4F4132C4AC7031F5692F36AC77A2ECBCCBB"
+
+	mockTorrentClient := &MockTorrentClient{}
+	mockTorrentClient.On("RemoveTorrents", []string{ownHash}).Return(clients.RemoveResults{
+		{Hash: ownHash, Status: clients.StatusRemoved},
+	})
+
+	sonarr := NewSonarr(appDir, "http://localhost", "testtoken", mockTorrentClient)
+	sonarr.index.saveIndexFile(sonarrIndexFileName(85), IndexFile{Hashes: []string{packHash, ownHash}})
+	sonarr.index.saveIndexFile(sonarrIndexFileName(86), IndexFile{Hashes: []string{packHash}})
+	NewIndex("radarr", appDir).saveIndexFile(radarrIndexFileName(1), IndexFile{Hashes: []string{strings.ToLower(packHash)}})
 
-	sonarr.HandleEvent("EpisodeFileDelete")
+	assert.True(t, sonarr.HandleEvent(context.Background(), "SeriesDelete", EventVars{"sonarr_series_id": "85"}))
 
+	mock.AssertExpectationsForObjects(t, mockTorrentClient)
+	references, err := sonarr.index.References(packHash)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []Reference{{Index: "sonarr", Key: "series_86"}, {Index: "radarr", Key: "movie_1"}}, references)
+}
+
+func TestSonarrReconcileKeepsIndexAndRefreshesChangedSeries(t *testing.T) {
+	defer gock.Off()
+	testUrl := "http://localhost"
+	indexedHash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+	grabbedHash := "BBBBB4F4132C4AC7031F5692F36AC77A2ECBCCBB"
+	newSeriesHash := "CCCCC4F4132C4AC7031F5692F36AC77A2ECBCCCC"
+
+	sonarr := NewSonarr(t.TempDir(), testUrl, "testtoken", nil)
+	gock.InterceptClient(sonarr.restClient.GetClient())
+	sonarr.index.saveIndexFile(sonarrIndexFileName(1), IndexFile{Hashes: []string{indexedHash}})
+	sonarr.index.saveIndexFile(sonarrIndexFileName(2), IndexFile{Hashes: []string{indexedHash}})
+	sonarr.index.saveIndexFile(sonarrIndexFileName(3), IndexFile{})
+	watermark := time.Now()
+	assert.NoError(t, sonarr.index.applyReconcile(nil, nil, &watermark))
+
+	gock.New(testUrl).Get("/api/v3/series").Reply(200).JSON(`[{"id": 1}, {"id": 2}, {"id": 4}]`)
+	gock.New(testUrl).Get("/api/v3/history/since").MatchParam("date", ".+").
+		Reply(200).JSON(`[{"seriesId": 2}, {"seriesId": 2}]`)
+	gock.New(testUrl).Get("/api/v3/history/series").MatchParam("seriesId", "2").
+		Reply(200).JSON(`[{"downloadId": "` + grabbedHash + `"}]`)
+	gock.New(testUrl).Get("/api/v3/history/series").MatchParam("seriesId", "4").
+		Reply(200).JSON(`[{"downloadId": "` + newSeriesHash + `"}]`)
+
+	assert.True(t, sonarr.buildIndex())
 	assert.True(t, gock.IsDone())
+
+	indexed := make(map[string]IndexFile)
+	assert.NoError(t, sonarr.index.ForEach(func(key string, indexFile IndexFile) error {
+		indexed[key] = indexFile
+		return nil
+	}))
+	assert.Equal(t, map[string]IndexFile{
+		"series_1": {Hashes: []string{indexedHash}},
+		"series_2": {Hashes: []string{grabbedHash, indexedHash}},
+		"series_4": {Hashes: []string{newSeriesHash}},
+	}, indexed)
+}
+
+func TestSonarrReconcileKeepsWatermarkWhenHistoryFails(t *testing.T) {
+	defer gock.Off()
+	testUrl := "http://localhost"
+	indexedHash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+	grabbedHash := "BBBBB4F4132C4AC7031F5692F36AC77A2ECBCCBB"
+
+	sonarr := NewSonarr(t.TempDir(), testUrl, "testtoken", nil)
+	gock.InterceptClient(sonarr.restClient.GetClient())
+	sonarr.index.saveIndexFile(sonarrIndexFileName(1), IndexFile{Hashes: []string{indexedHash}})
+	sonarr.index.saveIndexFile(sonarrIndexFileName(2), IndexFile{Hashes: []string{indexedHash}})
+	watermark := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	assert.NoError(t, sonarr.index.applyReconcile(nil, nil, &watermark))
+
+	gock.New(testUrl).Get("/api/v3/series").Reply(200).JSON(`[{"id": 1}, {"id": 2}]`)
+	gock.New(testUrl).Get("/api/v3/history/since").MatchParam("date", ".+").
+		Reply(200).JSON(`[{"seriesId": 1}, {"seriesId": 2}]`)
+	gock.New(testUrl).Get("/api/v3/history/series").MatchParam("seriesId", "1").
+		Reply(200).JSON(`[{"downloadId": "` + grabbedHash + `"}]`)
+	gock.New(testUrl).Get("/api/v3/history/series").MatchParam("seriesId", "2").
+		Reply(500)
+
+	assert.True(t, sonarr.buildIndex())
+	assert.True(t, gock.IsDone())
+
+	// Series 2 has to be refreshed again by the next reconcile
+	current, found, err := sonarr.index.watermark()
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.True(t, watermark.Equal(current))
+
+	indexed := make(map[string]IndexFile)
+	assert.NoError(t, sonarr.index.ForEach(func(key string, indexFile IndexFile) error {
+		indexed[key] = indexFile
+		return nil
+	}))
+	assert.Equal(t, map[string]IndexFile{
+		"series_1": {Hashes: []string{grabbedHash, indexedHash}},
+		"series_2": {Hashes: []string{indexedHash}},
+	}, indexed)
+}
+
+func TestSeriesDeleteKeepingFilesKeepsTorrents(t *testing.T) {
+	hash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+	mockTorrentClient := &MockTorrentClient{}
+
+	sonarr := NewSonarr(t.TempDir(), "http://localhost", "testtoken", mockTorrentClient)
+	sonarr.index.saveIndexFile(sonarrIndexFileName(85), IndexFile{Hashes: []string{hash}})
+
+	assert.True(t, sonarr.HandleEvent(context.Background(), "SeriesDelete", EventVars{
+		"sonarr_series_id":           "85",
+		"sonarr_series_deletedfiles": "False",
+	}))
+
+	mockTorrentClient.AssertNotCalled(t, "RemoveTorrents", mock.Anything)
+	_, found, err := sonarr.index.Get(sonarrIndexFileName(85))
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestSeriesDeleteKeepingFilesRemovesTorrentsWhenConfigured(t *testing.T) {
+	hash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+	mockTorrentClient := &MockTorrentClient{}
+	mockTorrentClient.On("RemoveTorrents", []string{hash}).Return(clients.RemoveResults{
+		{Hash: hash, Status: clients.StatusRemoved},
+	})
+
+	sonarr := NewSonarr(t.TempDir(), "http://localhost", "testtoken", mockTorrentClient)
+	sonarr.SetRemovalPolicy(RemovalPolicy{KeptFiles: KeptFilesRemove})
+	sonarr.index.saveIndexFile(sonarrIndexFileName(85), IndexFile{Hashes: []string{hash}})
+
+	assert.True(t, sonarr.HandleEvent(context.Background(), "SeriesDelete", EventVars{
+		"sonarr_series_id":           "85",
+		"sonarr_series_deletedfiles": "False",
+	}))
+
 	mock.AssertExpectationsForObjects(t, mockTorrentClient)
 }
+
+func TestEpisodeFileDeleteKeepsTorrentsWhenConfigured(t *testing.T) {
+	defer gock.Off()
+	testUrl := "http://localhost"
+	keptHash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+	otherHash := "BBBBB4F4132C4AC7031F5692F36AC77A2ECBCCBB"
+	mockTorrentClient := &MockTorrentClient{}
+
+	policies, err := ParseFileDeletePolicies(map[string]string{"missing_from_disk": "keep"})
+	assert.NoError(t, err)
+	sonarr := NewSonarr(t.TempDir(), testUrl, "testtoken", mockTorrentClient)
+	sonarr.SetRemovalPolicy(RemovalPolicy{FileDeletes: policies})
+	gock.InterceptClient(sonarr.restClient.GetClient())
+	gock.New(testUrl).Get("/api/v3/history/series").MatchParam("seriesId", "85").Reply(200).JSON(`[
+		{"episodeId": 3752, "downloadId": "` + keptHash + `", "date": "2025-01-01T10:00:00Z", "eventType": "downloadFolderImported"},
+		{"episodeId": 3753, "downloadId": "` + otherHash + `", "date": "2025-01-01T10:00:00Z", "eventType": "downloadFolderImported"}
+	]`)
+
+	assert.True(t, sonarr.HandleEvent(context.Background(), "EpisodeFileDelete", EventVars{
+		"sonarr_series_id":                "85",
+		"sonarr_episodefile_id":           "1512",
+		"sonarr_episodefile_episodeids":   "3752",
+		"sonarr_episodefile_deletereason": "MissingFromDisk",
+	}))
+
+	assert.True(t, gock.IsDone())
+	mockTorrentClient.AssertNotCalled(t, "RemoveTorrents", mock.Anything)
+	// The journal explains why the torrent is still seeding
+	entries, err := sonarr.journal.Entries(keptHash)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "EpisodeFileDelete", entries[0].Event)
+	assert.Empty(t, entries[0].Hashes)
+	assert.Equal(t, []string{keptHash}, entries[0].Kept)
+}
+
+func TestDeletedEpisodeFileGracePeriod(t *testing.T) {
+	defer gock.Off()
+	testUrl := "http://localhost"
+	hash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+
+	policies, err := ParseFileDeletePolicies(map[string]string{"Manual": "72h"})
+	assert.NoError(t, err)
+	for _, tc := range []struct {
+		deleted time.Time
+		removed []string
+	}{
+		{deleted: time.Now().Add(-time.Hour), removed: nil},
+		{deleted: time.Now().Add(-96 * time.Hour), removed: []string{hash}},
+	} {
+		mockTorrentClient := &MockTorrentClient{}
+		mockTorrentClient.On("RemoveTorrents", tc.removed).Return(nil)
+		sonarr := NewSonarr(t.TempDir(), testUrl, "testtoken", mockTorrentClient)
+		sonarr.SetRemovalPolicy(RemovalPolicy{FileDeletes: policies})
+		gock.InterceptClient(sonarr.restClient.GetClient())
+		gock.New(testUrl).Get("/api/v3/history/series").MatchParam("seriesId", "85").Reply(200).JSON(`[
+			{"episodeId": 1, "downloadId": "` + hash + `", "date": "` + tc.deleted.Add(-time.Hour).Format(time.RFC3339) + `", "eventType": "downloadFolderImported"},
+			{"episodeId": 1, "date": "` + tc.deleted.Format(time.RFC3339) + `", "eventType": "episodeFileDeleted", "data": {"reason": "Manual"}}
+		]`)
+
+		sonarr.removeOutdatedTorrents(context.Background(), 85, nil)
+
+		assert.True(t, gock.IsDone())
+		mock.AssertExpectationsForObjects(t, mockTorrentClient)
+	}
+}
+
+func TestEpisodeFileDeleteSchedulesRemovalAfterGracePeriod(t *testing.T) {
+	defer gock.Off()
+	testUrl := "http://localhost"
+	appDir := t.TempDir()
+	hash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+
+	policies, err := ParseFileDeletePolicies(map[string]string{"manual": "72h"})
+	assert.NoError(t, err)
+	mockTorrentClient := &MockTorrentClient{}
+	queue := clients.NewRemovalQueue(filepath.Join(appDir, ".queue", "removals.json"))
+	sonarr := NewSonarr(appDir, testUrl, "testtoken", clients.NewQueuedClient(mockTorrentClient, queue))
+	sonarr.SetRemovalPolicy(RemovalPolicy{FileDeletes: policies})
+	gock.InterceptClient(sonarr.restClient.GetClient())
+	gock.New(testUrl).Get("/api/v3/history/series").MatchParam("seriesId", "85").Reply(200).JSON(`[
+		{"episodeId": 3752, "downloadId": "` + hash + `", "date": "2025-01-01T10:00:00Z", "eventType": "downloadFolderImported"}
+	]`)
+
+	assert.True(t, sonarr.HandleEvent(context.Background(), "EpisodeFileDelete", EventVars{
+		"sonarr_series_id":                "85",
+		"sonarr_episodefile_id":           "1512",
+		"sonarr_episodefile_episodeids":   "3752",
+		"sonarr_episodefile_deletereason": "Manual",
+	}))
+
+	assert.True(t, gock.IsDone())
+	mockTorrentClient.AssertNotCalled(t, "RemoveTorrents", mock.Anything)
+	// The first queue drain after the grace period removes the torrent
+	pending := queue.Load()
+	assert.Len(t, pending, 1)
+	assert.Equal(t, hash, pending[0].Hash)
+	assert.WithinDuration(t, time.Now().Add(72*time.Hour), pending[0].NotBefore, time.Minute)
+	entries, err := sonarr.journal.Entries(hash)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, []JournalResult{{Hash: hash, Status: clients.StatusDeferred}}, entries[0].Results)
+}
+
+func TestParseFileDeletePolicies(t *testing.T) {
+	policies, err := ParseFileDeletePolicies(map[string]string{"upgrade": "remove", "MissingFromDisk": "keep", "manual": "24h"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]FileDeletePolicy{
+		"upgrade":         {},
+		"missingfromdisk": {Keep: true},
+		"manual":          {GracePeriod: 24 * time.Hour},
+	}, policies)
+
+	_, err = ParseFileDeletePolicies(map[string]string{"manual": "later"})
+	assert.Error(t, err)
+	_, err = ParseFileDeletePolicies(map[string]string{"renamed": "keep"})
+	assert.Error(t, err)
+}
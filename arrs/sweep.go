@@ -0,0 +1,48 @@
+package arrs
+
+import (
+	"arrcoon/clients"
+	"context"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Journal event of removals made by a sweep rather than an *arr event
+const sweepEvent = "Sweep"
+
+// Implemented by *arrs able to converge the torrent client back to their library state
+type Sweeper interface {
+	// Torrent client calls are made while holding the locker, it's shared with event handling
+	Sweep(ctx context.Context, locker sync.Locker) bool
+}
+
+// Runs the outdated torrent removal for every item, catching up with events missed while arrcoon or the *arr was down.
+// History is fetched without the locker, it's only held while the torrent client removes the item's torrents.
+func (i *Index) sweep(ctx context.Context, journal *Journal, locker sync.Locker, torrentClient clients.TorrentClient, itemIds []int, outdated func(itemId int) removal) bool {
+	var removed int
+	for _, itemId := range itemIds {
+		if ctx.Err() != nil {
+			log.WithError(ctx.Err()).WithFields(log.Fields{
+				"Index": i.name,
+			}).Warn("Sweep interrupted")
+			return false
+		}
+		itemRemoval := outdated(itemId)
+		// Most items have nothing to remove, they'd flood the journal
+		if len(itemRemoval.hashes) == 0 {
+			continue
+		}
+		locker.Lock()
+		itemRemoval.results = torrentClient.RemoveTorrents(ctx, itemRemoval.hashes)
+		locker.Unlock()
+		journal.record(i.name, sweepEvent, itemId, itemRemoval)
+		removed += len(itemRemoval.hashes)
+	}
+	log.WithFields(log.Fields{
+		"Index":   i.name,
+		"Items":   len(itemIds),
+		"Removed": removed,
+	}).Info("Sweep finished")
+	return true
+}
@@ -0,0 +1,55 @@
+package arrs
+
+import (
+	"arrcoon/clients"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSweepJournalsOnlyItemsWithRemovals(t *testing.T) {
+	appDir := t.TempDir()
+	hash := "AAAAAD29F161E9DD7B2BC43A53D5114760C764AA"
+	index := NewIndex("sonarr", appDir)
+	journal := NewJournal(appDir)
+	var mutex sync.Mutex
+
+	mockTorrentClient := &MockTorrentClient{}
+	mockTorrentClient.On("RemoveTorrents", []string{hash}).Run(func(args mock.Arguments) {
+		assert.False(t, mutex.TryLock(), "torrent client calls have to hold the lock")
+	}).Return(clients.RemoveResults{{Hash: hash, Status: clients.StatusRemoved}}).Once()
+
+	var swept []int
+	assert.True(t, index.sweep(context.Background(), journal, &mutex, mockTorrentClient, []int{1, 2, 3}, func(itemId int) removal {
+		// Events aren't held back while the *arr history is fetched
+		assert.True(t, mutex.TryLock(), "history requests mustn't hold the lock")
+		mutex.Unlock()
+		swept = append(swept, itemId)
+		if itemId == 2 {
+			return removal{hashes: []string{hash}}
+		}
+		return removal{}
+	}))
+
+	mock.AssertExpectationsForObjects(t, mockTorrentClient)
+	assert.Equal(t, []int{1, 2, 3}, swept)
+	entries, err := journal.Entries("")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "Sweep", entries[0].Event)
+	assert.Equal(t, 2, entries[0].ItemId)
+}
+
+func TestSweepStopsWhenCancelled(t *testing.T) {
+	index := NewIndex("radarr", t.TempDir())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.False(t, index.sweep(ctx, NewJournal(t.TempDir()), &sync.Mutex{}, &MockTorrentClient{}, []int{1}, func(itemId int) removal {
+		t.Fatal("cancelled sweep shouldn't remove torrents")
+		return removal{}
+	}))
+}
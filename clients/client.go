@@ -1,14 +1,188 @@
 package clients
 
+import (
+	"context"
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
 type ClientConfig map[string]interface{}
 
+type RemoveStatus string
+
+const (
+	StatusRemoved  RemoveStatus = "removed"
+	StatusNotFound RemoveStatus = "not_found"
+	StatusFailed   RemoveStatus = "failed"
+	StatusDryRun   RemoveStatus = "dry_run"
+	StatusSkipped  RemoveStatus = "skipped"
+	StatusDeferred RemoveStatus = "deferred"
+	// Stopped and labeled, removed once the quarantine period is over
+	StatusQuarantined RemoveStatus = "quarantined"
+	// Resumed after a quarantine or a mistaken removal request
+	StatusRestored RemoveStatus = "restored"
+)
+
+// Outcome of a single torrent removal
+type RemoveResult struct {
+	Hash   string
+	Status RemoveStatus
+	Err    error
+}
+
+type RemoveResults []RemoveResult
+
 type TorrentClient interface {
 	Test() bool
-	RemoveTorrents(hashes []string)
+	RemoveTorrents(ctx context.Context, hashes []string) RemoveResults
+	// Returns the subset of hashes which are present in the torrent client
+	ExistingTorrents(hashes []string) ([]string, error)
+	// Lists every torrent in the client along with its data location
+	ListTorrents(ctx context.Context) ([]TorrentContent, error)
+}
+
+// Implemented by clients which can dispatch hashes to the torrent client the *arr downloaded them with
+type HashRouter interface {
+	RouteHash(hash string, downloadClient string)
 }
 
-var Instances = map[string]func(clientConfig ClientConfig) TorrentClient{
+var Instances = map[string]func(clientConfig ClientConfig) (TorrentClient, error){
 	"rtorrent":     NewRtorrentClient,
 	"transmission": NewTransmissionClient,
 	"qbittorrent":  NewQbittorrentClient,
+	"deluge":       NewDelugeClient,
+}
+
+type Options struct {
+	// Removals are only recorded to this file when set
+	DryRunRecordPath string
+	// Where quarantined torrents are tracked between invocations
+	QuarantinePath string
+}
+
+// Creates torrent clients from the config section. The key is the client name,
+// the optional "type" value selects the implementation and defaults to the key.
+func NewFromConfig(configs map[string]ClientConfig, options Options) (TorrentClient, error) {
+	if len(configs) == 0 {
+		return nil, errors.New("no torrent clients defined")
+	}
+	torrentClients := make(map[string]TorrentClient)
+	aliases := make(map[string]string)
+	for name, config := range configs {
+		clientType := name
+		if configType, ok := config["type"].(string); ok && configType != "" {
+			clientType = configType
+		}
+		constructor, clientExists := Instances[clientType]
+		if !clientExists {
+			return nil, errors.New("couldn't map torrent client " + name + " of type " + clientType)
+		}
+		torrentClient, err := constructor(config)
+		if err != nil {
+			// An unreachable client mustn't keep the others from handling removals
+			log.WithError(err).WithFields(log.Fields{
+				"Client": name,
+			}).Error("Couldn't initialize torrent client, skipping it")
+			continue
+		}
+		if options.DryRunRecordPath != "" {
+			torrentClient = NewDryRunClient(torrentClient, options.DryRunRecordPath)
+		}
+		quarantinePeriod, err := parseQuarantinePeriod(config)
+		if err != nil {
+			return nil, errors.New("couldn't parse quarantine period of torrent client " + name + ": " + err.Error())
+		}
+		if quarantinePeriod > 0 {
+			torrentClient = NewQuarantineClient(torrentClient, quarantinePeriod, options.QuarantinePath)
+		}
+		retentionPolicy, err := parseRetentionPolicy(config)
+		if err != nil {
+			return nil, errors.New("couldn't parse retention rules of torrent client " + name + ": " + err.Error())
+		}
+		if retentionPolicy != nil {
+			torrentClient = NewRetentionClient(torrentClient, *retentionPolicy)
+		}
+		torrentClients[name] = torrentClient
+		// Names of the download client in *arr settings, used for history based routing
+		for _, arrName := range clientAliases(config) {
+			aliases[strings.ToLower(arrName)] = name
+		}
+	}
+	if len(torrentClients) == 0 {
+		return nil, errors.New("couldn't initialize any torrent client")
+	}
+	if len(torrentClients) == 1 {
+		for _, torrentClient := range torrentClients {
+			return torrentClient, nil
+		}
+	}
+	return NewMultiClient(torrentClients, aliases), nil
+}
+
+// The "name" value is either a single name or a list of them, *arrs may name the same client differently
+func clientAliases(config ClientConfig) []string {
+	var names []string
+	switch name := config["name"].(type) {
+	case string:
+		names = append(names, name)
+	case []string:
+		names = append(names, name...)
+	case []any:
+		for _, item := range name {
+			if itemName, ok := item.(string); ok {
+				names = append(names, itemName)
+			}
+		}
+	}
+	return slices.DeleteFunc(names, func(name string) bool {
+		return name == ""
+	})
+}
+
+// Parses the host of the client config, credentials are given as its user info
+func clientEndpoint(config ClientConfig) (*url.URL, error) {
+	host, _ := config["host"].(string)
+	if host == "" {
+		return nil, errors.New("torrent client has no host")
+	}
+	return url.Parse(host)
+}
+
+// Returns requested hashes which are present in found hashes, ignoring the case
+func matchHashes(requested []string, found []string) []string {
+	foundMap := make(map[string]struct{}, len(found))
+	for _, hash := range found {
+		foundMap[strings.ToUpper(hash)] = struct{}{}
+	}
+	var matched []string
+	for _, hash := range requested {
+		if _, ok := foundMap[strings.ToUpper(hash)]; ok {
+			matched = append(matched, hash)
+		}
+	}
+	return matched
+}
+
+// Returns hashes which couldn't be removed and are worth a retry
+func (rr RemoveResults) Failed() []string {
+	var failed []string
+	for _, result := range rr {
+		if result.Status == StatusFailed {
+			failed = append(failed, result.Hash)
+		}
+	}
+	return failed
+}
+
+// Builds results with the same status for every hash
+func resultsFor(hashes []string, status RemoveStatus, err error) RemoveResults {
+	results := make(RemoveResults, len(hashes))
+	for i, hash := range hashes {
+		results[i] = RemoveResult{Hash: hash, Status: status, Err: err}
+	}
+	return results
 }
@@ -0,0 +1,353 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+type DelugeClient struct {
+	restClient *resty.Client
+	password   string
+	requestId  atomic.Int64
+	sharedData SharedDataPolicy
+}
+
+type DelugeTorrentStatus struct {
+	Hash     string `json:"hash"`
+	Name     string `json:"name"`
+	SavePath string `json:"save_path"`
+}
+
+type DelugeRequest struct {
+	Method string `json:"method"`
+	Params []any  `json:"params"`
+	Id     int    `json:"id"`
+}
+
+type DelugeError struct {
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+type DelugeResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *DelugeError    `json:"error"`
+	Id     int             `json:"id"`
+}
+
+func NewDelugeClient(config ClientConfig) (TorrentClient, error) {
+	endpoint, err := clientEndpoint(config)
+	if err != nil {
+		return nil, err
+	}
+	password, _ := endpoint.User.Password()
+	// Deluge Web UI serves JSON-RPC on the /json path relative to its base url
+	jsonUrl := endpoint.Scheme + "://" + endpoint.Host + strings.TrimSuffix(endpoint.Path, "/") + "/json"
+	return &DelugeClient{
+		restClient: resty.New().SetBaseURL(jsonUrl),
+		password:   password,
+		sharedData: sharedDataPolicy(config),
+	}, nil
+}
+
+func (dc *DelugeClient) call(ctx context.Context, method string, params []any, result any) error {
+	requestId := int(dc.requestId.Add(1))
+	if params == nil {
+		params = []any{}
+	}
+	var response DelugeResponse
+	_, err := dc.restClient.R().
+		SetContext(ctx).
+		SetBody(DelugeRequest{Method: method, Params: params, Id: requestId}).
+		SetResult(&response).
+		Post("")
+	if err != nil {
+		return err
+	}
+	if response.Error != nil {
+		return errors.New(response.Error.Message)
+	}
+	if result != nil && len(response.Result) > 0 {
+		return json.Unmarshal(response.Result, result)
+	}
+	return nil
+}
+
+// Logs into the Deluge Web UI and makes sure it is connected to a daemon
+func (dc *DelugeClient) login(ctx context.Context) error {
+	var authenticated bool
+	if err := dc.call(ctx, "auth.login", []any{dc.password}, &authenticated); err != nil {
+		return err
+	}
+	if !authenticated {
+		return errors.New("deluge authentication failed")
+	}
+
+	var connected bool
+	if err := dc.call(ctx, "web.connected", nil, &connected); err != nil {
+		return err
+	}
+	if connected {
+		return nil
+	}
+
+	// Web UI isn't attached to a daemon yet, connect to the first known host
+	var hosts [][]any
+	if err := dc.call(ctx, "web.get_hosts", nil, &hosts); err != nil {
+		return err
+	}
+	if len(hosts) == 0 || len(hosts[0]) == 0 {
+		return errors.New("no deluge daemons configured in the web UI")
+	}
+	return dc.call(ctx, "web.connect", []any{hosts[0][0]}, nil)
+}
+
+func (dc *DelugeClient) Test() bool {
+	ctx := context.Background()
+	log.Info("Testing Deluge accessibility")
+	if err := dc.login(ctx); err != nil {
+		log.WithError(err).Error("Couldn't connect to deluge")
+		return false
+	}
+	var version string
+	if err := dc.call(ctx, "daemon.get_version", nil, &version); err != nil {
+		log.WithError(err).Error("Couldn't get deluge version")
+		return false
+	}
+	log.WithFields(log.Fields{
+		"Version": version,
+	}).Info("Succesfully connected to deluge")
+	return true
+}
+
+func (dc *DelugeClient) RemoveTorrents(ctx context.Context, hashes []string) RemoveResults {
+	if len(hashes) == 0 {
+		return nil
+	}
+	if err := dc.login(ctx); err != nil {
+		log.WithError(err).Error("Couldn't connect to deluge")
+		return resultsFor(hashes, StatusFailed, err)
+	}
+	// All torrents are needed to find the ones sharing data with the removed ones
+	var filterHashes []string
+	if dc.sharedData == SharedDataDelete {
+		filterHashes = hashes
+	}
+	contents, err := dc.contents(ctx, filterHashes)
+	if err != nil {
+		log.WithError(err).Error("Couldn't get deluge torrents")
+		return resultsFor(hashes, StatusFailed, err)
+	}
+	found := make([]string, len(contents))
+	for i, content := range contents {
+		found[i] = content.Hash
+	}
+	existing := matchHashes(hashes, found)
+	results := resultsFor(removeHashes(hashes, existing), StatusNotFound, nil)
+
+	withData, withoutData, skipped := planRemoval(dc.sharedData, existing, contents, func(hash string) ([]string, error) {
+		return dc.torrentFiles(ctx, hash)
+	})
+	results = append(results, resultsFor(skipped, StatusSkipped, nil)...)
+	for _, hash := range withData {
+		results = append(results, dc.removeTorrent(ctx, hash, true))
+	}
+	for _, hash := range withoutData {
+		results = append(results, dc.removeTorrent(ctx, hash, false))
+	}
+	return results
+}
+
+func (dc *DelugeClient) removeTorrent(ctx context.Context, hash string, removeData bool) RemoveResult {
+	var removed bool
+	// Deluge keeps torrent ids as lowercase info hashes
+	err := dc.call(ctx, "core.remove_torrent", []any{strings.ToLower(hash), removeData}, &removed)
+	if err == nil && !removed {
+		err = errors.New("deluge didn't remove the torrent")
+	}
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"Hash": hash,
+		}).Error("Couldn't remove torrent")
+		return RemoveResult{Hash: hash, Status: StatusFailed, Err: err}
+	}
+	log.WithFields(log.Fields{
+		"Hash":        hash,
+		"Remove data": removeData,
+	}).Info("Torrent has been removed")
+	return RemoveResult{Hash: hash, Status: StatusRemoved}
+}
+
+// Pauses the torrents, labels depend on an optional plugin and are left alone
+func (dc *DelugeClient) QuarantineTorrents(ctx context.Context, hashes []string) RemoveResults {
+	if len(hashes) == 0 {
+		return nil
+	}
+	if err := dc.login(ctx); err != nil {
+		log.WithError(err).Error("Couldn't connect to deluge")
+		return resultsFor(hashes, StatusFailed, err)
+	}
+	existing, err := dc.existingTorrents(ctx, hashes)
+	if err != nil {
+		log.WithError(err).Error("Couldn't get deluge torrents")
+		return resultsFor(hashes, StatusFailed, err)
+	}
+	results := resultsFor(removeHashes(hashes, existing), StatusNotFound, nil)
+	if len(existing) == 0 {
+		return results
+	}
+	torrentIds := make([]string, len(existing))
+	for i, hash := range existing {
+		torrentIds[i] = strings.ToLower(hash)
+	}
+	if err := dc.call(ctx, "core.pause_torrents", []any{torrentIds}, nil); err != nil {
+		log.WithError(err).Error("Couldn't quarantine deluge torrents")
+		return append(results, resultsFor(existing, StatusFailed, err)...)
+	}
+	return append(results, resultsFor(existing, StatusQuarantined, nil)...)
+}
+
+func (dc *DelugeClient) RestoreTorrents(ctx context.Context, hashes []string) RemoveResults {
+	if len(hashes) == 0 {
+		return nil
+	}
+	if err := dc.login(ctx); err != nil {
+		log.WithError(err).Error("Couldn't connect to deluge")
+		return resultsFor(hashes, StatusFailed, err)
+	}
+	existing, err := dc.existingTorrents(ctx, hashes)
+	if err != nil {
+		log.WithError(err).Error("Couldn't get deluge torrents")
+		return resultsFor(hashes, StatusFailed, err)
+	}
+	results := resultsFor(removeHashes(hashes, existing), StatusNotFound, nil)
+	if len(existing) == 0 {
+		return results
+	}
+	torrentIds := make([]string, len(existing))
+	for i, hash := range existing {
+		torrentIds[i] = strings.ToLower(hash)
+	}
+	if err := dc.call(ctx, "core.resume_torrents", []any{torrentIds}, nil); err != nil {
+		log.WithError(err).Error("Couldn't restore deluge torrents")
+		return append(results, resultsFor(existing, StatusFailed, err)...)
+	}
+	return append(results, resultsFor(existing, StatusRestored, nil)...)
+}
+
+func (dc *DelugeClient) ExistingTorrents(hashes []string) ([]string, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	ctx := context.Background()
+	if err := dc.login(ctx); err != nil {
+		return nil, err
+	}
+	return dc.existingTorrents(ctx, hashes)
+}
+
+func (dc *DelugeClient) ListTorrents(ctx context.Context) ([]TorrentContent, error) {
+	if err := dc.login(ctx); err != nil {
+		return nil, err
+	}
+	return dc.contents(ctx, nil)
+}
+
+func (dc *DelugeClient) existingTorrents(ctx context.Context, hashes []string) ([]string, error) {
+	contents, err := dc.contents(ctx, hashes)
+	if err != nil {
+		return nil, err
+	}
+	found := make([]string, len(contents))
+	for i, content := range contents {
+		found[i] = content.Hash
+	}
+	return matchHashes(hashes, found), nil
+}
+
+// File paths are relative to the save path and include the torrent root folder
+func (dc *DelugeClient) torrentFiles(ctx context.Context, hash string) ([]string, error) {
+	var torrents map[string]struct {
+		SavePath string `json:"save_path"`
+		Files    []struct {
+			Path string `json:"path"`
+		} `json:"files"`
+	}
+	filter := map[string]any{"id": []string{strings.ToLower(hash)}}
+	err := dc.call(ctx, "core.get_torrents_status", []any{filter, []string{"save_path", "files"}}, &torrents)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, torrent := range torrents {
+		for _, file := range torrent.Files {
+			paths = append(paths, path.Join(torrent.SavePath, file.Path))
+		}
+	}
+	return paths, nil
+}
+
+// Lists data locations of the given torrents, or of all torrents when no hashes are given
+func (dc *DelugeClient) contents(ctx context.Context, hashes []string) ([]TorrentContent, error) {
+	filter := map[string]any{}
+	if len(hashes) > 0 {
+		lowerHashes := make([]string, len(hashes))
+		for i, hash := range hashes {
+			lowerHashes[i] = strings.ToLower(hash)
+		}
+		filter["id"] = lowerHashes
+	}
+	var torrents map[string]DelugeTorrentStatus
+	err := dc.call(ctx, "core.get_torrents_status", []any{filter, []string{"hash", "name", "save_path"}}, &torrents)
+	if err != nil {
+		return nil, err
+	}
+	contents := make([]TorrentContent, 0, len(torrents))
+	for hash, torrent := range torrents {
+		contents = append(contents, TorrentContent{Hash: hash, Path: path.Join(torrent.SavePath, torrent.Name)})
+	}
+	return contents, nil
+}
+
+func (dc *DelugeClient) TorrentStats(ctx context.Context, hashes []string) (map[string]TorrentStats, error) {
+	if err := dc.login(ctx); err != nil {
+		return nil, err
+	}
+	lowerHashes := make([]string, len(hashes))
+	for i, hash := range hashes {
+		lowerHashes[i] = strings.ToLower(hash)
+	}
+	var torrents map[string]struct {
+		Ratio       float64 `json:"ratio"`
+		SeedingTime int64   `json:"seeding_time"`
+		Private     bool    `json:"private"`
+		TrackerHost string  `json:"tracker_host"`
+	}
+	keys := []string{"ratio", "seeding_time", "private", "tracker_host"}
+	err := dc.call(ctx, "core.get_torrents_status", []any{map[string]any{"id": lowerHashes}, keys}, &torrents)
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]TorrentStats, len(torrents))
+	for hash, torrent := range torrents {
+		torrentStats := TorrentStats{
+			Hash:        hash,
+			Ratio:       torrent.Ratio,
+			SeedingTime: time.Duration(torrent.SeedingTime) * time.Second,
+			Private:     torrent.Private,
+		}
+		if torrent.TrackerHost != "" {
+			torrentStats.Trackers = []string{torrent.TrackerHost}
+		}
+		stats[strings.ToUpper(hash)] = torrentStats
+	}
+	return stats, nil
+}
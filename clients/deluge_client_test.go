@@ -0,0 +1,111 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type delugeMockServer struct {
+	server  *httptest.Server
+	calls   []DelugeRequest
+	results map[string]any
+}
+
+func newDelugeMockServer(results map[string]any) *delugeMockServer {
+	mockServer := &delugeMockServer{results: results}
+	mockServer.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request DelugeRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		mockServer.calls = append(mockServer.calls, request)
+		response := map[string]any{"result": mockServer.results[request.Method], "error": nil, "id": request.Id}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}))
+	return mockServer
+}
+
+func (m *delugeMockServer) methods() []string {
+	var methods []string
+	for _, call := range m.calls {
+		methods = append(methods, call.Method)
+	}
+	return methods
+}
+
+func TestDelugeTest(t *testing.T) {
+	mockServer := newDelugeMockServer(map[string]any{
+		"auth.login":         true,
+		"web.connected":      true,
+		"daemon.get_version": "2.1.1",
+	})
+	defer mockServer.server.Close()
+
+	client, err := NewDelugeClient(ClientConfig{"host": mockServer.server.URL})
+	assert.NoError(t, err)
+
+	assert.True(t, client.Test())
+	assert.Equal(t, []string{"auth.login", "web.connected", "daemon.get_version"}, mockServer.methods())
+}
+
+func TestDelugeRemove(t *testing.T) {
+	mockServer := newDelugeMockServer(map[string]any{
+		"auth.login":    true,
+		"web.connected": false,
+		"web.get_hosts": [][]any{{"c5a1a1c2e7f8", "127.0.0.1", 58846, "localclient"}},
+		"web.connect":   []string{},
+		"core.get_torrents_status": map[string]any{
+			"aaa65110ba16ef7839c27604b41ab083c832d83c": map[string]any{"hash": "aaa65110ba16ef7839c27604b41ab083c832d83c"},
+		},
+		"core.remove_torrent": true,
+	})
+	defer mockServer.server.Close()
+
+	client, err := NewDelugeClient(ClientConfig{"host": mockServer.server.URL})
+	assert.NoError(t, err)
+	results := client.RemoveTorrents(context.Background(), []string{"AAA65110BA16EF7839C27604B41AB083C832D83C", "BBB65110BA16EF7839C27604B41AB083C832D83C"})
+
+	assert.Equal(t, []string{"auth.login", "web.connected", "web.get_hosts", "web.connect", "core.get_torrents_status", "core.remove_torrent"}, mockServer.methods())
+	assert.Equal(t, []any{"c5a1a1c2e7f8"}, mockServer.calls[3].Params)
+	assert.Equal(t, []any{"aaa65110ba16ef7839c27604b41ab083c832d83c", true}, mockServer.calls[5].Params)
+	assert.ElementsMatch(t, RemoveResults{
+		{Hash: "AAA65110BA16EF7839C27604B41AB083C832D83C", Status: StatusRemoved},
+		{Hash: "BBB65110BA16EF7839C27604B41AB083C832D83C", Status: StatusNotFound},
+	}, results)
+}
+
+func TestDelugeRemoveRejected(t *testing.T) {
+	mockServer := newDelugeMockServer(map[string]any{
+		"auth.login":    true,
+		"web.connected": true,
+		"core.get_torrents_status": map[string]any{
+			"aaa65110ba16ef7839c27604b41ab083c832d83c": map[string]any{"hash": "aaa65110ba16ef7839c27604b41ab083c832d83c"},
+		},
+		"core.remove_torrent": false,
+	})
+	defer mockServer.server.Close()
+
+	client, err := NewDelugeClient(ClientConfig{"host": mockServer.server.URL})
+	assert.NoError(t, err)
+	results := client.RemoveTorrents(context.Background(), []string{"AAA65110BA16EF7839C27604B41AB083C832D83C"})
+
+	assert.Equal(t, []string{"AAA65110BA16EF7839C27604B41AB083C832D83C"}, results.Failed())
+}
+
+func TestDelugeRemoveUnauthorized(t *testing.T) {
+	mockServer := newDelugeMockServer(map[string]any{
+		"auth.login": false,
+	})
+	defer mockServer.server.Close()
+
+	client, err := NewDelugeClient(ClientConfig{"host": mockServer.server.URL})
+	assert.NoError(t, err)
+	results := client.RemoveTorrents(context.Background(), []string{"AAA65110BA16EF7839C27604B41AB083C832D83C"})
+
+	assert.Equal(t, []string{"auth.login"}, mockServer.methods())
+	assert.Equal(t, []string{"AAA65110BA16EF7839C27604B41AB083C832D83C"}, results.Failed())
+}
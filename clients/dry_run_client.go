@@ -0,0 +1,152 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Wraps a torrent client and only records removals instead of performing them
+type DryRunClient struct {
+	client     TorrentClient
+	recordPath string
+}
+
+type DryRunRecord struct {
+	Time time.Time `json:"time"`
+	// Set to quarantine for quarantines, empty for removals
+	Action    string   `json:"action,omitempty"`
+	Requested []string `json:"requested"`
+	Existing  []string `json:"existing"`
+}
+
+func NewDryRunClient(client TorrentClient, recordPath string) *DryRunClient {
+	return &DryRunClient{
+		client:     client,
+		recordPath: recordPath,
+	}
+}
+
+func (drc *DryRunClient) Test() bool {
+	return drc.client.Test()
+}
+
+func (drc *DryRunClient) ExistingTorrents(hashes []string) ([]string, error) {
+	return drc.client.ExistingTorrents(hashes)
+}
+
+func (drc *DryRunClient) ListTorrents(ctx context.Context) ([]TorrentContent, error) {
+	return drc.client.ListTorrents(ctx)
+}
+
+func (drc *DryRunClient) RouteHash(hash string, downloadClient string) {
+	if router, ok := drc.client.(HashRouter); ok {
+		router.RouteHash(hash, downloadClient)
+	}
+}
+
+func (drc *DryRunClient) TorrentStats(ctx context.Context, hashes []string) (map[string]TorrentStats, error) {
+	statsClient, ok := drc.client.(StatsClient)
+	if !ok {
+		return nil, errors.New("torrent client doesn't report seeding stats")
+	}
+	return statsClient.TorrentStats(ctx, hashes)
+}
+
+func (drc *DryRunClient) RemoveTorrents(ctx context.Context, hashes []string) RemoveResults {
+	if len(hashes) == 0 {
+		return nil
+	}
+	existing, err := drc.client.ExistingTorrents(hashes)
+	if err != nil {
+		log.WithError(err).Error("Couldn't check torrents")
+		return resultsFor(hashes, StatusFailed, err)
+	}
+	log.WithFields(log.Fields{
+		"Hashes":   hashes,
+		"Existing": existing,
+	}).Info("Dry run, torrents would have been removed")
+	drc.record(DryRunRecord{
+		Time:      time.Now(),
+		Requested: hashes,
+		Existing:  existing,
+	})
+	return append(resultsFor(existing, StatusDryRun, nil), resultsFor(removeHashes(hashes, existing), StatusNotFound, nil)...)
+}
+
+// Records quarantines like removals, the quarantine client keeps no state for them
+func (drc *DryRunClient) QuarantineTorrents(ctx context.Context, hashes []string) RemoveResults {
+	if len(hashes) == 0 {
+		return nil
+	}
+	if _, ok := drc.client.(Quarantiner); !ok {
+		log.Warn("Torrent client can't quarantine torrents, removing them right away")
+		return drc.RemoveTorrents(ctx, hashes)
+	}
+	existing, err := drc.client.ExistingTorrents(hashes)
+	if err != nil {
+		log.WithError(err).Error("Couldn't check torrents")
+		return resultsFor(hashes, StatusFailed, err)
+	}
+	log.WithFields(log.Fields{
+		"Hashes":   hashes,
+		"Existing": existing,
+	}).Info("Dry run, torrents would have been quarantined")
+	drc.record(DryRunRecord{
+		Time:      time.Now(),
+		Action:    "quarantine",
+		Requested: hashes,
+		Existing:  existing,
+	})
+	return append(resultsFor(existing, StatusDryRun, nil), resultsFor(removeHashes(hashes, existing), StatusNotFound, nil)...)
+}
+
+func (drc *DryRunClient) RestoreTorrents(ctx context.Context, hashes []string) RemoveResults {
+	if len(hashes) == 0 {
+		return nil
+	}
+	existing, err := drc.client.ExistingTorrents(hashes)
+	if err != nil {
+		log.WithError(err).Error("Couldn't check torrents")
+		return resultsFor(hashes, StatusFailed, err)
+	}
+	log.WithFields(log.Fields{
+		"Hashes":   hashes,
+		"Existing": existing,
+	}).Info("Dry run, torrents would have been restored")
+	return append(resultsFor(existing, StatusDryRun, nil), resultsFor(removeHashes(hashes, existing), StatusNotFound, nil)...)
+}
+
+func (drc *DryRunClient) record(record DryRunRecord) {
+	if drc.recordPath == "" {
+		return
+	}
+	err := os.MkdirAll(filepath.Dir(drc.recordPath), os.ModePerm)
+	if err != nil {
+		log.WithError(err).Error("Failed to create a directory for dry run record")
+		return
+	}
+	jsonBytes, err := json.Marshal(record)
+	if err != nil {
+		log.WithError(err).Error("Error marshaling dry run record")
+		return
+	}
+	file, err := os.OpenFile(drc.recordPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"File Path": drc.recordPath,
+		}).Error("Error opening dry run record")
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(jsonBytes, '\n')); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"File Path": drc.recordPath,
+		}).Error("Error writing dry run record")
+	}
+}
@@ -0,0 +1,62 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestDryRunRemove(t *testing.T) {
+	hashA := "AAA65110BA16EF7839C27604B41AB083C832D83C"
+	hashB := "BBB65110BA16EF7839C27604B41AB083C832D83C"
+
+	client := new(MockClient)
+	client.On("ExistingTorrents", []string{hashA, hashB}).Return([]string{hashA}, nil)
+
+	recordPath := filepath.Join(t.TempDir(), "dry_run.jsonl")
+	dryRunClient := NewDryRunClient(client, recordPath)
+	results := dryRunClient.RemoveTorrents(context.Background(), []string{hashA, hashB})
+
+	mock.AssertExpectationsForObjects(t, client)
+	client.AssertNotCalled(t, "RemoveTorrents", mock.Anything)
+	assert.Equal(t, RemoveResults{
+		{Hash: hashA, Status: StatusDryRun},
+		{Hash: hashB, Status: StatusNotFound},
+	}, results)
+
+	recordBytes, err := os.ReadFile(recordPath)
+	assert.NoError(t, err)
+	var record DryRunRecord
+	assert.NoError(t, json.Unmarshal([]byte(strings.TrimSpace(string(recordBytes))), &record))
+	assert.Equal(t, []string{hashA, hashB}, record.Requested)
+	assert.Equal(t, []string{hashA}, record.Existing)
+}
+
+func TestDryRunQuarantine(t *testing.T) {
+	hash := "AAA65110BA16EF7839C27604B41AB083C832D83C"
+
+	client := new(MockQuarantineClient)
+	client.On("ExistingTorrents", []string{hash}).Return([]string{hash}, nil)
+
+	recordPath := filepath.Join(t.TempDir(), "dry_run.jsonl")
+	quarantineClient := NewQuarantineClient(NewDryRunClient(client, recordPath), 24*time.Hour, filepath.Join(t.TempDir(), "quarantine.json"))
+	results := quarantineClient.RemoveTorrents(context.Background(), []string{hash})
+
+	client.AssertNotCalled(t, "QuarantineTorrents", mock.Anything)
+	client.AssertNotCalled(t, "RemoveTorrents", mock.Anything)
+	assert.Equal(t, RemoveResults{{Hash: hash, Status: StatusDryRun}}, results)
+
+	recordBytes, err := os.ReadFile(recordPath)
+	assert.NoError(t, err)
+	var record DryRunRecord
+	assert.NoError(t, json.Unmarshal([]byte(strings.TrimSpace(string(recordBytes))), &record))
+	assert.Equal(t, "quarantine", record.Action)
+	assert.Equal(t, []string{hash}, record.Existing)
+}
@@ -0,0 +1,198 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Dispatches calls to several torrent clients configured for the same *arr installation
+type MultiClient struct {
+	clients map[string]TorrentClient
+	aliases map[string]string
+	// Written by history lookups and read by removals, which run concurrently in serve mode
+	routesLock sync.RWMutex
+	routes     map[string]string
+}
+
+func NewMultiClient(clients map[string]TorrentClient, aliases map[string]string) *MultiClient {
+	if aliases == nil {
+		aliases = make(map[string]string)
+	}
+	return &MultiClient{
+		clients: clients,
+		aliases: aliases,
+		routes:  make(map[string]string),
+	}
+}
+
+// Remembers the *arr download client a hash was grabbed with
+func (mc *MultiClient) RouteHash(hash string, downloadClient string) {
+	if name, ok := mc.clientName(downloadClient); ok {
+		mc.routesLock.Lock()
+		defer mc.routesLock.Unlock()
+		mc.routes[strings.ToUpper(hash)] = name
+	}
+}
+
+func (mc *MultiClient) clientName(downloadClient string) (string, bool) {
+	if downloadClient == "" {
+		return "", false
+	}
+	if name, ok := mc.aliases[strings.ToLower(downloadClient)]; ok {
+		return name, true
+	}
+	for name := range mc.clients {
+		if strings.EqualFold(name, downloadClient) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (mc *MultiClient) names() []string {
+	names := make([]string, 0, len(mc.clients))
+	for name := range mc.clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (mc *MultiClient) Test() bool {
+	success := true
+	for _, name := range mc.names() {
+		log.WithFields(log.Fields{
+			"Torrent Client": name,
+		}).Info("Testing torrent client")
+		if !mc.clients[name].Test() {
+			success = false
+		}
+	}
+	return success
+}
+
+func (mc *MultiClient) ExistingTorrents(hashes []string) ([]string, error) {
+	var existing []string
+	for _, name := range mc.names() {
+		clientExisting, err := mc.clients[name].ExistingTorrents(hashes)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't check torrents of %s: %w", name, err)
+		}
+		existing = append(existing, clientExisting...)
+	}
+	return existing, nil
+}
+
+func (mc *MultiClient) ListTorrents(ctx context.Context) ([]TorrentContent, error) {
+	var contents []TorrentContent
+	for _, name := range mc.names() {
+		clientContents, err := mc.clients[name].ListTorrents(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list torrents of %s: %w", name, err)
+		}
+		contents = append(contents, clientContents...)
+	}
+	return contents, nil
+}
+
+func (mc *MultiClient) RemoveTorrents(ctx context.Context, hashes []string) RemoveResults {
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	// Hashes with a known download client go straight to it
+	routed := make(map[string][]string)
+	var unrouted []string
+	mc.routesLock.RLock()
+	for _, hash := range hashes {
+		if name, ok := mc.routes[strings.ToUpper(hash)]; ok {
+			routed[name] = append(routed[name], hash)
+		} else {
+			unrouted = append(unrouted, hash)
+		}
+	}
+	mc.routesLock.RUnlock()
+
+	var results RemoveResults
+	// Unrouted hashes may belong to a client which couldn't be asked
+	var lookupErr error
+	for _, name := range mc.names() {
+		clientHashes := routed[name]
+		if len(unrouted) > 0 {
+			existing, err := mc.clients[name].ExistingTorrents(unrouted)
+			if err != nil {
+				log.WithError(err).WithFields(log.Fields{
+					"Torrent Client": name,
+				}).Error("Couldn't check torrents")
+				lookupErr = fmt.Errorf("couldn't check torrents of %s: %w", name, err)
+			}
+			clientHashes = append(clientHashes, existing...)
+			unrouted = removeHashes(unrouted, existing)
+		}
+		if len(clientHashes) == 0 {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"Torrent Client": name,
+			"Hashes":         clientHashes,
+		}).Debug("Routing torrents removal")
+		results = append(results, mc.clients[name].RemoveTorrents(ctx, clientHashes)...)
+	}
+
+	if len(unrouted) > 0 && lookupErr != nil {
+		results = append(results, resultsFor(unrouted, StatusFailed, lookupErr)...)
+	} else if len(unrouted) > 0 {
+		log.WithFields(log.Fields{
+			"Hashes": unrouted,
+		}).Info("Torrents not found in any torrent client")
+		results = append(results, resultsFor(unrouted, StatusNotFound, nil)...)
+	}
+	return results
+}
+
+// Restores every torrent with the client it's present in
+func (mc *MultiClient) RestoreTorrents(ctx context.Context, hashes []string) RemoveResults {
+	var results RemoveResults
+	var lookupErr error
+	remaining := hashes
+	for _, name := range mc.names() {
+		if len(remaining) == 0 {
+			break
+		}
+		existing, err := mc.clients[name].ExistingTorrents(remaining)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"Torrent Client": name,
+			}).Error("Couldn't check torrents")
+			lookupErr = fmt.Errorf("couldn't check torrents of %s: %w", name, err)
+		}
+		if len(existing) == 0 {
+			continue
+		}
+		results = append(results, restoreTorrents(ctx, mc.clients[name], existing)...)
+		remaining = removeHashes(remaining, existing)
+	}
+	if lookupErr != nil {
+		return append(results, resultsFor(remaining, StatusFailed, lookupErr)...)
+	}
+	return append(results, resultsFor(remaining, StatusNotFound, nil)...)
+}
+
+func removeHashes(hashes []string, removed []string) []string {
+	removedMap := make(map[string]struct{}, len(removed))
+	for _, hash := range removed {
+		removedMap[strings.ToUpper(hash)] = struct{}{}
+	}
+	var remaining []string
+	for _, hash := range hashes {
+		if _, ok := removedMap[strings.ToUpper(hash)]; !ok {
+			remaining = append(remaining, hash)
+		}
+	}
+	return remaining
+}
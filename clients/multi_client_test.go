@@ -0,0 +1,150 @@
+package clients
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockClient struct {
+	mock.Mock
+}
+
+func (m *MockClient) Test() bool {
+	args := m.Called()
+	return args.Bool(0)
+}
+
+func (m *MockClient) RemoveTorrents(ctx context.Context, hashes []string) RemoveResults {
+	args := m.Called(hashes)
+	return args.Get(0).(RemoveResults)
+}
+
+func (m *MockClient) ExistingTorrents(hashes []string) ([]string, error) {
+	args := m.Called(hashes)
+	existing, _ := args.Get(0).([]string)
+	return existing, args.Error(1)
+}
+
+func (m *MockClient) ListTorrents(ctx context.Context) ([]TorrentContent, error) {
+	args := m.Called()
+	return args.Get(0).([]TorrentContent), args.Error(1)
+}
+
+func TestMultiClientRemoveByExistingHashes(t *testing.T) {
+	hashA := "AAA65110BA16EF7839C27604B41AB083C832D83C"
+	hashB := "BBB65110BA16EF7839C27604B41AB083C832D83C"
+
+	qbittorrent := new(MockClient)
+	qbittorrent.On("ExistingTorrents", []string{hashA, hashB}).Return([]string{hashB}, nil)
+	qbittorrent.On("RemoveTorrents", []string{hashB}).Return(resultsFor([]string{hashB}, StatusRemoved, nil))
+
+	rtorrent := new(MockClient)
+	rtorrent.On("ExistingTorrents", []string{hashA}).Return([]string{hashA}, nil)
+	rtorrent.On("RemoveTorrents", []string{hashA}).Return(resultsFor([]string{hashA}, StatusRemoved, nil))
+
+	client := NewMultiClient(map[string]TorrentClient{"qbittorrent": qbittorrent, "rtorrent": rtorrent}, nil)
+	client.RemoveTorrents(context.Background(), []string{hashA, hashB})
+
+	mock.AssertExpectationsForObjects(t, qbittorrent, rtorrent)
+}
+
+func TestMultiClientRemoveByRoutedHashes(t *testing.T) {
+	hashA := "AAA65110BA16EF7839C27604B41AB083C832D83C"
+	hashB := "BBB65110BA16EF7839C27604B41AB083C832D83C"
+
+	qbittorrent := new(MockClient)
+	qbittorrent.On("RemoveTorrents", []string{hashB}).Return(resultsFor([]string{hashB}, StatusRemoved, nil))
+
+	rtorrent := new(MockClient)
+	rtorrent.On("RemoveTorrents", []string{hashA}).Return(resultsFor([]string{hashA}, StatusRemoved, nil))
+
+	client := NewMultiClient(
+		map[string]TorrentClient{"qbittorrent-4k": qbittorrent, "rtorrent": rtorrent},
+		map[string]string{"qbittorrent 4k": "qbittorrent-4k"},
+	)
+	client.RouteHash(hashA, "rTorrent")
+	client.RouteHash(hashB, "qBittorrent 4K")
+	client.RemoveTorrents(context.Background(), []string{hashA, hashB})
+
+	mock.AssertExpectationsForObjects(t, qbittorrent, rtorrent)
+	qbittorrent.AssertNotCalled(t, "ExistingTorrents", mock.Anything)
+	rtorrent.AssertNotCalled(t, "ExistingTorrents", mock.Anything)
+}
+
+func TestMultiClientRemoveWithUnreachableClient(t *testing.T) {
+	hashA := "AAA65110BA16EF7839C27604B41AB083C832D83C"
+	hashB := "BBB65110BA16EF7839C27604B41AB083C832D83C"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	qbittorrent := new(MockClient)
+	qbittorrent.On("ExistingTorrents", []string{hashA, hashB}).Return([]string{hashB}, nil)
+	qbittorrent.On("RemoveTorrents", []string{hashB}).Return(resultsFor([]string{hashB}, StatusRemoved, nil))
+	transmission, err := NewTransmissionClient(ClientConfig{"host": server.URL + "/transmission/rpc"})
+	assert.NoError(t, err)
+
+	client := NewMultiClient(map[string]TorrentClient{"qbittorrent": qbittorrent, "transmission": transmission}, nil)
+	results := client.RemoveTorrents(context.Background(), []string{hashA, hashB})
+
+	mock.AssertExpectationsForObjects(t, qbittorrent)
+	// The torrent may be in the unreachable client, so its removal is retried later
+	assert.Equal(t, []string{hashA}, results.Failed())
+}
+
+func TestNewFromConfigSkipsFailingClients(t *testing.T) {
+	// qBittorrent rejects the login without the password the *arr API didn't reveal
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Fails."))
+	}))
+	defer server.Close()
+	qbittorrentHost := strings.Replace(server.URL, "http://", "http://admin@", 1)
+
+	client, err := NewFromConfig(map[string]ClientConfig{
+		"qbittorrent": {"host": qbittorrentHost},
+		"deluge":      {"host": "http://localhost:8112"},
+	}, Options{})
+	assert.NoError(t, err)
+	assert.IsType(t, &DelugeClient{}, client)
+
+	_, err = NewFromConfig(map[string]ClientConfig{
+		"qbittorrent": {"host": qbittorrentHost},
+		"rtorrent":    {},
+	}, Options{})
+	assert.Error(t, err)
+}
+
+func TestNewFromConfigRoutesEveryAlias(t *testing.T) {
+	hash := "AAA65110BA16EF7839C27604B41AB083C832D83C"
+	qbittorrent := new(MockClient)
+	qbittorrent.On("RemoveTorrents", []string{hash}).Return(resultsFor([]string{hash}, StatusRemoved, nil)).Twice()
+	rtorrent := new(MockClient)
+	Instances["mock-qbittorrent"] = func(ClientConfig) (TorrentClient, error) { return qbittorrent, nil }
+	Instances["mock-rtorrent"] = func(ClientConfig) (TorrentClient, error) { return rtorrent, nil }
+	defer delete(Instances, "mock-qbittorrent")
+	defer delete(Instances, "mock-rtorrent")
+
+	// Sonarr and Radarr name the same qBittorrent differently
+	client, err := NewFromConfig(map[string]ClientConfig{
+		"qbittorrent": {"type": "mock-qbittorrent", "name": []any{"qBittorrent", "qBit Movies"}},
+		"rtorrent":    {"type": "mock-rtorrent", "name": "rTorrent"},
+	}, Options{})
+	assert.NoError(t, err)
+
+	router := client.(HashRouter)
+	for _, downloadClient := range []string{"qBittorrent", "qBit Movies"} {
+		router.RouteHash(hash, downloadClient)
+		client.RemoveTorrents(context.Background(), []string{hash})
+	}
+
+	mock.AssertExpectationsForObjects(t, qbittorrent)
+	rtorrent.AssertNotCalled(t, "RemoveTorrents", mock.Anything)
+}
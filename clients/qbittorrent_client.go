@@ -1,7 +1,10 @@
 package clients
 
 import (
-	"net/url"
+	"context"
+	"path"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -10,12 +13,13 @@ import (
 
 type QBittorentClient struct {
 	qbittorrentClient *qbittorrent.Client
+	sharedData        SharedDataPolicy
 }
 
-func NewQbittorrentClient(config ClientConfig) TorrentClient {
-	endpoint, err := url.Parse(config["host"].(string))
+func NewQbittorrentClient(config ClientConfig) (TorrentClient, error) {
+	endpoint, err := clientEndpoint(config)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	password, _ := endpoint.User.Password()
 	client := qbittorrent.NewClient(qbittorrent.Config{
@@ -23,11 +27,10 @@ func NewQbittorrentClient(config ClientConfig) TorrentClient {
 		Username: endpoint.User.Username(),
 		Password: password,
 	})
-	err = client.Login()
-	if err != nil {
-		log.Fatal(err)
+	if err := client.Login(); err != nil {
+		return nil, err
 	}
-	return &QBittorentClient{qbittorrentClient: client}
+	return &QBittorentClient{qbittorrentClient: client, sharedData: sharedDataPolicy(config)}, nil
 }
 
 func (qbc QBittorentClient) Test() bool {
@@ -42,13 +45,177 @@ func (qbc QBittorentClient) Test() bool {
 	return true
 }
 
-func (qbc QBittorentClient) RemoveTorrents(hashes []string) {
-	err := qbc.qbittorrentClient.DeleteTorrents(hashes, true)
+func (qbc QBittorentClient) RemoveTorrents(ctx context.Context, hashes []string) RemoveResults {
+	if len(hashes) == 0 {
+		return nil
+	}
+	// All torrents are needed to find the ones sharing data with the removed ones
+	filter := qbittorrent.TorrentFilterOptions{}
+	if qbc.sharedData == SharedDataDelete {
+		filter.Hashes = hashes
+	}
+	torrents, err := qbc.qbittorrentClient.GetTorrentsCtx(ctx, filter)
+	if err != nil {
+		log.WithError(err).Error("Couldn't get qbittorrent torrents")
+		return resultsFor(hashes, StatusFailed, err)
+	}
+	var found []string
+	contents := make([]TorrentContent, 0, len(torrents))
+	savePaths := make(map[string]string, len(torrents))
+	for _, torrent := range torrents {
+		found = append(found, torrent.Hash)
+		contents = append(contents, TorrentContent{Hash: torrent.Hash, Path: torrent.ContentPath})
+		savePaths[strings.ToUpper(torrent.Hash)] = torrent.SavePath
+	}
+	existing := matchHashes(hashes, found)
+	results := resultsFor(removeHashes(hashes, existing), StatusNotFound, nil)
+
+	withData, withoutData, skipped := planRemoval(qbc.sharedData, existing, contents, func(hash string) ([]string, error) {
+		return qbc.torrentFiles(ctx, hash, savePaths[strings.ToUpper(hash)])
+	})
+	results = append(results, resultsFor(skipped, StatusSkipped, nil)...)
+	results = append(results, qbc.deleteTorrents(ctx, withData, true)...)
+	results = append(results, qbc.deleteTorrents(ctx, withoutData, false)...)
+	return results
+}
+
+// File names are relative to the save path and include the torrent root folder
+func (qbc QBittorentClient) torrentFiles(ctx context.Context, hash string, savePath string) ([]string, error) {
+	files, err := qbc.qbittorrentClient.GetFilesInformationCtx(ctx, hash)
+	if err != nil || files == nil {
+		return nil, err
+	}
+	paths := make([]string, len(*files))
+	for i, file := range *files {
+		paths[i] = path.Join(savePath, file.Name)
+	}
+	return paths, nil
+}
+
+func (qbc QBittorentClient) deleteTorrents(ctx context.Context, hashes []string, deleteFiles bool) RemoveResults {
+	if len(hashes) == 0 {
+		return nil
+	}
+	err := qbc.qbittorrentClient.DeleteTorrentsCtx(ctx, hashes, deleteFiles)
 	if err != nil {
 		log.WithError(err).Error("Error while removing qbittorrent torrents")
-	} else {
-		log.WithFields(log.Fields{
-			"Hashes": hashes,
-		}).Info("Successfully removed qbittorrent torrents")
+		return resultsFor(hashes, StatusFailed, err)
+	}
+	log.WithFields(log.Fields{
+		"Hashes":       hashes,
+		"Delete Files": deleteFiles,
+	}).Info("Successfully removed qbittorrent torrents")
+	return resultsFor(hashes, StatusRemoved, nil)
+}
+
+// Stops the torrents and tags them, categories are left alone as they may move the data
+func (qbc QBittorentClient) QuarantineTorrents(ctx context.Context, hashes []string) RemoveResults {
+	if len(hashes) == 0 {
+		return nil
+	}
+	torrents, err := qbc.qbittorrentClient.GetTorrentsCtx(ctx, qbittorrent.TorrentFilterOptions{Hashes: hashes})
+	if err != nil {
+		log.WithError(err).Error("Couldn't get qbittorrent torrents")
+		return resultsFor(hashes, StatusFailed, err)
+	}
+	var found []string
+	for _, torrent := range torrents {
+		found = append(found, torrent.Hash)
+	}
+	existing := matchHashes(hashes, found)
+	results := resultsFor(removeHashes(hashes, existing), StatusNotFound, nil)
+	if len(existing) == 0 {
+		return results
+	}
+	err = qbc.qbittorrentClient.StopCtx(ctx, existing)
+	if err == nil {
+		err = qbc.qbittorrentClient.AddTagsCtx(ctx, existing, QuarantineLabel)
+	}
+	if err != nil {
+		log.WithError(err).Error("Couldn't quarantine qbittorrent torrents")
+		return append(results, resultsFor(existing, StatusFailed, err)...)
+	}
+	return append(results, resultsFor(existing, StatusQuarantined, nil)...)
+}
+
+// Starts the torrents and drops the quarantine tag
+func (qbc QBittorentClient) RestoreTorrents(ctx context.Context, hashes []string) RemoveResults {
+	if len(hashes) == 0 {
+		return nil
+	}
+	torrents, err := qbc.qbittorrentClient.GetTorrentsCtx(ctx, qbittorrent.TorrentFilterOptions{Hashes: hashes})
+	if err != nil {
+		log.WithError(err).Error("Couldn't get qbittorrent torrents")
+		return resultsFor(hashes, StatusFailed, err)
+	}
+	var found []string
+	for _, torrent := range torrents {
+		found = append(found, torrent.Hash)
+	}
+	existing := matchHashes(hashes, found)
+	results := resultsFor(removeHashes(hashes, existing), StatusNotFound, nil)
+	if len(existing) == 0 {
+		return results
+	}
+	err = qbc.qbittorrentClient.RemoveTagsCtx(ctx, existing, QuarantineLabel)
+	if err == nil {
+		err = qbc.qbittorrentClient.StartCtx(ctx, existing)
+	}
+	if err != nil {
+		log.WithError(err).Error("Couldn't restore qbittorrent torrents")
+		return append(results, resultsFor(existing, StatusFailed, err)...)
+	}
+	return append(results, resultsFor(existing, StatusRestored, nil)...)
+}
+
+func (qbc QBittorentClient) ExistingTorrents(hashes []string) ([]string, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	torrents, err := qbc.qbittorrentClient.GetTorrents(qbittorrent.TorrentFilterOptions{Hashes: hashes})
+	if err != nil {
+		return nil, err
+	}
+	var found []string
+	for _, torrent := range torrents {
+		found = append(found, torrent.Hash)
+	}
+	return matchHashes(hashes, found), nil
+}
+
+func (qbc QBittorentClient) ListTorrents(ctx context.Context) ([]TorrentContent, error) {
+	torrents, err := qbc.qbittorrentClient.GetTorrentsCtx(ctx, qbittorrent.TorrentFilterOptions{})
+	if err != nil {
+		return nil, err
+	}
+	contents := make([]TorrentContent, len(torrents))
+	for i, torrent := range torrents {
+		contents[i] = TorrentContent{Hash: torrent.Hash, Path: torrent.ContentPath}
+	}
+	return contents, nil
+}
+
+func (qbc QBittorentClient) TorrentStats(ctx context.Context, hashes []string) (map[string]TorrentStats, error) {
+	torrents, err := qbc.qbittorrentClient.GetTorrentsCtx(ctx, qbittorrent.TorrentFilterOptions{Hashes: hashes})
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]TorrentStats, len(torrents))
+	for _, torrent := range torrents {
+		var trackers []string
+		if torrent.Tracker != "" {
+			trackers = append(trackers, torrent.Tracker)
+		}
+		for _, tracker := range torrent.Trackers {
+			trackers = append(trackers, tracker.Url)
+		}
+		stats[strings.ToUpper(torrent.Hash)] = TorrentStats{
+			Hash:        torrent.Hash,
+			Ratio:       torrent.Ratio,
+			SeedingTime: time.Duration(torrent.SeedingTime) * time.Second,
+			Private:     torrent.Private,
+			Trackers:    trackers,
+		}
 	}
+	return stats, nil
 }
@@ -0,0 +1,216 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Label or tag given to quarantined torrents
+const QuarantineLabel = "arrcoon-quarantine"
+
+// Implemented by clients able to stop and label torrents instead of removing them right away
+type Quarantiner interface {
+	// Returns StatusQuarantined for every stopped and labeled torrent
+	QuarantineTorrents(ctx context.Context, hashes []string) RemoveResults
+}
+
+// Implemented by clients able to resume quarantined torrents and drop their quarantine label
+type Restorer interface {
+	// Returns StatusRestored for every resumed torrent
+	RestoreTorrents(ctx context.Context, hashes []string) RemoveResults
+}
+
+// Torrent kept stopped in the client until its quarantine period runs out
+type QuarantinedTorrent struct {
+	Hash  string    `json:"hash"`
+	Since time.Time `json:"since"`
+}
+
+// Wraps a torrent client and quarantines torrents for a period before the actual removal
+type QuarantineClient struct {
+	client TorrentClient
+	period time.Duration
+	path   string
+}
+
+func parseQuarantinePeriod(config ClientConfig) (time.Duration, error) {
+	quarantine, ok := config["quarantine"].(string)
+	if !ok || quarantine == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(quarantine)
+}
+
+func NewQuarantineClient(client TorrentClient, period time.Duration, path string) *QuarantineClient {
+	return &QuarantineClient{
+		client: client,
+		period: period,
+		path:   path,
+	}
+}
+
+func (qc *QuarantineClient) Test() bool {
+	return qc.client.Test()
+}
+
+func (qc *QuarantineClient) ExistingTorrents(hashes []string) ([]string, error) {
+	return qc.client.ExistingTorrents(hashes)
+}
+
+func (qc *QuarantineClient) ListTorrents(ctx context.Context) ([]TorrentContent, error) {
+	return qc.client.ListTorrents(ctx)
+}
+
+func (qc *QuarantineClient) RouteHash(hash string, downloadClient string) {
+	if router, ok := qc.client.(HashRouter); ok {
+		router.RouteHash(hash, downloadClient)
+	}
+}
+
+func (qc *QuarantineClient) TorrentStats(ctx context.Context, hashes []string) (map[string]TorrentStats, error) {
+	statsClient, ok := qc.client.(StatsClient)
+	if !ok {
+		return nil, errors.New("torrent client doesn't report seeding stats")
+	}
+	return statsClient.TorrentStats(ctx, hashes)
+}
+
+func (qc *QuarantineClient) RemoveTorrents(ctx context.Context, hashes []string) RemoveResults {
+	if len(hashes) == 0 {
+		return nil
+	}
+	quarantiner, ok := qc.client.(Quarantiner)
+	if !ok {
+		log.Warn("Torrent client can't quarantine torrents, removing them right away")
+		return qc.client.RemoveTorrents(ctx, hashes)
+	}
+
+	defer lockPath(qc.path)()
+	quarantined := qc.load()
+	now := time.Now()
+	var results RemoveResults
+	var fresh, expired []string
+	for _, hash := range hashes {
+		entry, ok := quarantined[strings.ToUpper(hash)]
+		switch {
+		case !ok:
+			fresh = append(fresh, hash)
+		case now.Sub(entry.Since) >= qc.period:
+			expired = append(expired, hash)
+		default:
+			results = append(results, RemoveResult{Hash: hash, Status: StatusQuarantined})
+		}
+	}
+
+	if len(fresh) > 0 {
+		for _, result := range quarantiner.QuarantineTorrents(ctx, fresh) {
+			if result.Status == StatusQuarantined {
+				quarantined[strings.ToUpper(result.Hash)] = QuarantinedTorrent{Hash: result.Hash, Since: now}
+				log.WithFields(log.Fields{
+					"Hash":  result.Hash,
+					"Until": now.Add(qc.period).Format(time.RFC3339),
+				}).Info("Torrent has been quarantined")
+			}
+			results = append(results, result)
+		}
+	}
+
+	if len(expired) > 0 {
+		log.WithFields(log.Fields{
+			"Hashes": expired,
+		}).Info("Quarantine period is over, removing torrents")
+		for _, result := range qc.client.RemoveTorrents(ctx, expired) {
+			if !queuedStatuses[result.Status] {
+				delete(quarantined, strings.ToUpper(result.Hash))
+			}
+			results = append(results, result)
+		}
+	}
+
+	qc.save(quarantined)
+	return results
+}
+
+func (qc *QuarantineClient) RestoreTorrents(ctx context.Context, hashes []string) RemoveResults {
+	defer lockPath(qc.path)()
+	results := restoreTorrents(ctx, qc.client, hashes)
+	quarantined := qc.load()
+	for _, result := range results {
+		if result.Status != StatusFailed {
+			delete(quarantined, strings.ToUpper(result.Hash))
+		}
+	}
+	qc.save(quarantined)
+	return results
+}
+
+// Restores torrents with the wrapped client, failing when it can't restore torrents
+func restoreTorrents(ctx context.Context, client TorrentClient, hashes []string) RemoveResults {
+	if len(hashes) == 0 {
+		return nil
+	}
+	restorer, ok := client.(Restorer)
+	if !ok {
+		return resultsFor(hashes, StatusFailed, errors.New("torrent client can't restore torrents"))
+	}
+	return restorer.RestoreTorrents(ctx, hashes)
+}
+
+func (qc *QuarantineClient) load() map[string]QuarantinedTorrent {
+	quarantined := make(map[string]QuarantinedTorrent)
+	jsonBytes, err := os.ReadFile(qc.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return quarantined
+	}
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"File Path": qc.path,
+		}).Error("Error reading quarantine")
+		return quarantined
+	}
+	var entries []QuarantinedTorrent
+	if err := json.Unmarshal(jsonBytes, &entries); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"File Path": qc.path,
+		}).Error("Error unmarshaling quarantine")
+		return quarantined
+	}
+	for _, entry := range entries {
+		quarantined[strings.ToUpper(entry.Hash)] = entry
+	}
+	return quarantined
+}
+
+func (qc *QuarantineClient) save(quarantined map[string]QuarantinedTorrent) {
+	if err := os.MkdirAll(filepath.Dir(qc.path), os.ModePerm); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"File Path": qc.path,
+		}).Error("Failed to create a directory for quarantine")
+		return
+	}
+	entries := make([]QuarantinedTorrent, 0, len(quarantined))
+	for _, entry := range quarantined {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Hash < entries[j].Hash
+	})
+	jsonBytes, err := json.Marshal(entries)
+	if err != nil {
+		log.WithError(err).Error("Error marshaling quarantine")
+		return
+	}
+	if err := writeFileAtomic(qc.path, jsonBytes); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"File Path": qc.path,
+		}).Error("Error writing quarantine")
+	}
+}
@@ -0,0 +1,156 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hekmon/transmissionrpc/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockQuarantineClient struct {
+	MockClient
+}
+
+func (m *MockQuarantineClient) QuarantineTorrents(ctx context.Context, hashes []string) RemoveResults {
+	args := m.Called(hashes)
+	return args.Get(0).(RemoveResults)
+}
+
+func TestQuarantineClientDelaysRemoval(t *testing.T) {
+	hash := "AAA65110BA16EF7839C27604B41AB083C832D83C"
+	missingHash := "BBB65110BA16EF7839C27604B41AB083C832D83C"
+
+	client := new(MockQuarantineClient)
+	client.On("QuarantineTorrents", []string{hash, missingHash}).Return(RemoveResults{
+		{Hash: hash, Status: StatusQuarantined},
+		{Hash: missingHash, Status: StatusNotFound},
+	}).Once()
+
+	quarantineClient := NewQuarantineClient(client, 24*time.Hour, filepath.Join(t.TempDir(), "quarantine.json"))
+
+	results := quarantineClient.RemoveTorrents(context.Background(), []string{hash, missingHash})
+	assert.Equal(t, RemoveResults{
+		{Hash: hash, Status: StatusQuarantined},
+		{Hash: missingHash, Status: StatusNotFound},
+	}, results)
+
+	// The torrent stays quarantined until the period is over
+	results = quarantineClient.RemoveTorrents(context.Background(), []string{hash})
+	assert.Equal(t, RemoveResults{{Hash: hash, Status: StatusQuarantined}}, results)
+
+	mock.AssertExpectationsForObjects(t, client)
+	client.AssertNotCalled(t, "RemoveTorrents", mock.Anything)
+}
+
+func TestQuarantineClientRemovesExpiredTorrents(t *testing.T) {
+	hash := "AAA65110BA16EF7839C27604B41AB083C832D83C"
+
+	client := new(MockQuarantineClient)
+	client.On("RemoveTorrents", []string{hash}).Return(RemoveResults{{Hash: hash, Status: StatusRemoved}})
+
+	quarantineClient := NewQuarantineClient(client, 24*time.Hour, filepath.Join(t.TempDir(), "quarantine.json"))
+	quarantineClient.save(map[string]QuarantinedTorrent{
+		hash: {Hash: hash, Since: time.Now().Add(-48 * time.Hour)},
+	})
+
+	results := quarantineClient.RemoveTorrents(context.Background(), []string{hash})
+
+	assert.Equal(t, RemoveResults{{Hash: hash, Status: StatusRemoved}}, results)
+	assert.Empty(t, quarantineClient.load())
+	mock.AssertExpectationsForObjects(t, client)
+}
+
+func TestQuarantineClientKeepsConcurrentQuarantines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quarantine.json")
+
+	// Every custom script invocation quarantines from its own process
+	var wg sync.WaitGroup
+	for i := range 20 {
+		hash := fmt.Sprintf("%040X", i)
+		client := new(MockQuarantineClient)
+		client.On("QuarantineTorrents", []string{hash}).Return(RemoveResults{{Hash: hash, Status: StatusQuarantined}})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			NewQuarantineClient(client, 24*time.Hour, path).RemoveTorrents(context.Background(), []string{hash})
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, NewQuarantineClient(nil, 24*time.Hour, path).load(), 20)
+}
+
+func TestTransmissionQuarantine(t *testing.T) {
+	mockTransmissionClient := new(MockTransmissionRPC)
+	id := int64(22)
+	hashString := "BBB65110BA16EF7839C27604B41AB083C832D83C"
+	hashes := []string{"AAA65110BA16EF7839C27604B41AB083C832D83C", hashString}
+	mockTransmissionClient.On("TorrentGetAllForHashes", mock.Anything, hashes).Return([]transmissionrpc.Torrent{
+		{ID: &id, HashString: &hashString, Labels: []string{"tv"}},
+	}, nil)
+	mockTransmissionClient.On("TorrentStopIDs", mock.Anything, []int64{id}).Return(nil)
+	mockTransmissionClient.On("TorrentSet", mock.Anything, transmissionrpc.TorrentSetPayload{
+		IDs:    []int64{id},
+		Labels: []string{"tv", QuarantineLabel},
+	}).Return(nil)
+
+	client := TransmissionClient{transmissionClient: mockTransmissionClient}
+
+	results := client.QuarantineTorrents(context.Background(), hashes)
+
+	mock.AssertExpectationsForObjects(t, mockTransmissionClient)
+	assert.Equal(t, RemoveResults{
+		{Hash: hashString, Status: StatusQuarantined},
+		{Hash: "AAA65110BA16EF7839C27604B41AB083C832D83C", Status: StatusNotFound},
+	}, results)
+}
+
+func (m *MockQuarantineClient) RestoreTorrents(ctx context.Context, hashes []string) RemoveResults {
+	args := m.Called(hashes)
+	return args.Get(0).(RemoveResults)
+}
+
+func TestQuarantineClientRestore(t *testing.T) {
+	hash := "AAA65110BA16EF7839C27604B41AB083C832D83C"
+
+	client := new(MockQuarantineClient)
+	client.On("RestoreTorrents", []string{hash}).Return(RemoveResults{{Hash: hash, Status: StatusRestored}})
+
+	quarantineClient := NewQuarantineClient(client, 24*time.Hour, filepath.Join(t.TempDir(), "quarantine.json"))
+	quarantineClient.save(map[string]QuarantinedTorrent{
+		hash: {Hash: hash, Since: time.Now()},
+	})
+
+	results := quarantineClient.RestoreTorrents(context.Background(), []string{hash})
+
+	assert.Equal(t, RemoveResults{{Hash: hash, Status: StatusRestored}}, results)
+	assert.Empty(t, quarantineClient.load())
+	mock.AssertExpectationsForObjects(t, client)
+}
+
+func TestTransmissionRestore(t *testing.T) {
+	mockTransmissionClient := new(MockTransmissionRPC)
+	id := int64(22)
+	hashString := "BBB65110BA16EF7839C27604B41AB083C832D83C"
+	mockTransmissionClient.On("TorrentGetAllForHashes", mock.Anything, []string{hashString}).Return([]transmissionrpc.Torrent{
+		{ID: &id, HashString: &hashString, Labels: []string{"tv", QuarantineLabel}},
+	}, nil)
+	mockTransmissionClient.On("TorrentSet", mock.Anything, transmissionrpc.TorrentSetPayload{
+		IDs:    []int64{id},
+		Labels: []string{"tv"},
+	}).Return(nil)
+	mockTransmissionClient.On("TorrentStartIDs", mock.Anything, []int64{id}).Return(nil)
+
+	client := TransmissionClient{transmissionClient: mockTransmissionClient}
+
+	results := client.RestoreTorrents(context.Background(), []string{hashString})
+
+	mock.AssertExpectationsForObjects(t, mockTransmissionClient)
+	assert.Equal(t, RemoveResults{{Hash: hashString, Status: StatusRestored}}, results)
+}
@@ -0,0 +1,331 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Torrent waiting to be removed by a later invocation
+type PendingRemoval struct {
+	Hash      string       `json:"hash"`
+	Status    RemoveStatus `json:"status"`
+	Since     time.Time    `json:"since"`
+	Checked   time.Time    `json:"checked"`
+	Attempts  int          `json:"attempts"`
+	LastError string       `json:"last_error,omitempty"`
+	// Scheduled removals aren't retried before this time
+	NotBefore time.Time `json:"not_before,omitzero"`
+}
+
+// Implemented by clients able to remove torrents later on, e.g. once a grace period is over
+type Scheduler interface {
+	// Returns StatusDeferred for every torrent queued for removal
+	ScheduleRemovals(hashes []string, notBefore time.Time) RemoveResults
+	// Drops scheduled removals, failed and deferred ones stay queued
+	UnscheduleRemovals(hashes []string)
+}
+
+// File backed queue of torrents whose removal failed or was deferred
+type RemovalQueue struct {
+	path string
+}
+
+// Wraps a torrent client and parks failed and deferred removals in the queue
+type QueuedClient struct {
+	client TorrentClient
+	queue  *RemovalQueue
+}
+
+// Removal outcomes which keep a torrent in the queue
+var queuedStatuses = map[RemoveStatus]bool{
+	StatusFailed:      true,
+	StatusDeferred:    true,
+	StatusQuarantined: true,
+}
+
+// Removal outcomes which leave queued torrents as they are, nothing was attempted for them
+var untouchedStatuses = map[RemoveStatus]bool{
+	StatusDryRun:  true,
+	StatusSkipped: true,
+}
+
+func NewRemovalQueue(path string) *RemovalQueue {
+	return &RemovalQueue{path: path}
+}
+
+func (rq *RemovalQueue) Load() []PendingRemoval {
+	jsonBytes, err := os.ReadFile(rq.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"File Path": rq.path,
+		}).Error("Error reading removal queue")
+		return nil
+	}
+	var pending []PendingRemoval
+	if err := json.Unmarshal(jsonBytes, &pending); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"File Path": rq.path,
+		}).Error("Error unmarshaling removal queue")
+		return nil
+	}
+	return pending
+}
+
+func (rq *RemovalQueue) save(pending []PendingRemoval) bool {
+	if err := os.MkdirAll(filepath.Dir(rq.path), os.ModePerm); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"File Path": rq.path,
+		}).Error("Failed to create a directory for removal queue")
+		return false
+	}
+	if len(pending) == 0 {
+		if err := os.Remove(rq.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.WithError(err).WithFields(log.Fields{
+				"File Path": rq.path,
+			}).Error("Error removing removal queue")
+			return false
+		}
+		return true
+	}
+	jsonBytes, err := json.Marshal(pending)
+	if err != nil {
+		log.WithError(err).Error("Error marshaling removal queue")
+		return false
+	}
+	if err := writeFileAtomic(rq.path, jsonBytes); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"File Path": rq.path,
+		}).Error("Error writing removal queue")
+		return false
+	}
+	return true
+}
+
+// Writes through a uniquely named temporary file, concurrent processes may write the same file
+func writeFileAtomic(path string, data []byte) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
+
+// Takes an exclusive lock on a file shared with other arrcoon processes, the returned function releases it.
+// Read-modify-write sequences have to hold it as *arrs fire events in parallel.
+func lockPath(path string) func() {
+	lockPath := path + ".lock"
+	if err := os.MkdirAll(filepath.Dir(lockPath), os.ModePerm); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"File Path": lockPath,
+		}).Error("Failed to create a directory for lock")
+		return func() {}
+	}
+	file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"File Path": lockPath,
+		}).Error("Error opening lock")
+		return func() {}
+	}
+	if err := lockFile(file); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"File Path": lockPath,
+		}).Error("Error locking file")
+		file.Close()
+		return func() {}
+	}
+	return func() {
+		unlockFile(file)
+		file.Close()
+	}
+}
+
+// Records removal outcomes, queueing failed and deferred torrents and dropping settled ones
+func (rq *RemovalQueue) Update(results RemoveResults) {
+	if len(results) == 0 {
+		return
+	}
+	defer lockPath(rq.path)()
+	rq.update(results)
+}
+
+// Has to be called with the queue locked
+func (rq *RemovalQueue) update(results RemoveResults) {
+	if len(results) == 0 {
+		return
+	}
+	now := time.Now()
+	pending := rq.Load()
+	pendingIndex := make(map[string]int, len(pending))
+	for i, entry := range pending {
+		pendingIndex[strings.ToUpper(entry.Hash)] = i
+	}
+
+	settled := make(map[string]struct{})
+	for _, result := range results {
+		key := strings.ToUpper(result.Hash)
+		i, queued := pendingIndex[key]
+		switch {
+		case untouchedStatuses[result.Status]:
+			continue
+		case queuedStatuses[result.Status]:
+			if !queued {
+				i = len(pending)
+				pendingIndex[key] = i
+				pending = append(pending, PendingRemoval{Hash: result.Hash, Since: now})
+			}
+			pending[i].Status = result.Status
+			pending[i].Checked = now
+			pending[i].Attempts++
+			pending[i].LastError = ""
+			if result.Err != nil {
+				pending[i].LastError = result.Err.Error()
+			}
+		case queued:
+			settled[key] = struct{}{}
+		}
+	}
+
+	remaining := make([]PendingRemoval, 0, len(pending))
+	for _, entry := range pending {
+		if _, ok := settled[strings.ToUpper(entry.Hash)]; !ok {
+			remaining = append(remaining, entry)
+		}
+	}
+	rq.save(remaining)
+}
+
+func NewQueuedClient(client TorrentClient, queue *RemovalQueue) *QueuedClient {
+	return &QueuedClient{
+		client: client,
+		queue:  queue,
+	}
+}
+
+func (qc *QueuedClient) Test() bool {
+	return qc.client.Test()
+}
+
+func (qc *QueuedClient) ExistingTorrents(hashes []string) ([]string, error) {
+	return qc.client.ExistingTorrents(hashes)
+}
+
+func (qc *QueuedClient) ListTorrents(ctx context.Context) ([]TorrentContent, error) {
+	return qc.client.ListTorrents(ctx)
+}
+
+func (qc *QueuedClient) RouteHash(hash string, downloadClient string) {
+	if router, ok := qc.client.(HashRouter); ok {
+		router.RouteHash(hash, downloadClient)
+	}
+}
+
+func (qc *QueuedClient) RemoveTorrents(ctx context.Context, hashes []string) RemoveResults {
+	results := qc.client.RemoveTorrents(ctx, hashes)
+	qc.queue.Update(results)
+	return results
+}
+
+// Restores torrents and drops them from the queue
+func (qc *QueuedClient) RestoreTorrents(ctx context.Context, hashes []string) RemoveResults {
+	results := restoreTorrents(ctx, qc.client, hashes)
+	qc.queue.Update(results)
+	return results
+}
+
+// Queues torrents for removal by the first Drain after notBefore
+func (qc *QueuedClient) ScheduleRemovals(hashes []string, notBefore time.Time) RemoveResults {
+	if len(hashes) == 0 {
+		return nil
+	}
+	defer lockPath(qc.queue.path)()
+	now := time.Now()
+	pending := qc.queue.Load()
+	pendingIndex := make(map[string]int, len(pending))
+	for i, entry := range pending {
+		pendingIndex[strings.ToUpper(entry.Hash)] = i
+	}
+	for _, hash := range hashes {
+		// Queued torrents are already on their way out
+		if _, queued := pendingIndex[strings.ToUpper(hash)]; queued {
+			continue
+		}
+		pendingIndex[strings.ToUpper(hash)] = len(pending)
+		pending = append(pending, PendingRemoval{Hash: hash, Status: StatusDeferred, Since: now, NotBefore: notBefore})
+	}
+	qc.queue.save(pending)
+	log.WithFields(log.Fields{
+		"Hashes":     hashes,
+		"Not Before": notBefore.Format(time.RFC3339),
+	}).Info("Torrent removals have been scheduled")
+	return resultsFor(hashes, StatusDeferred, nil)
+}
+
+func (qc *QueuedClient) UnscheduleRemovals(hashes []string) {
+	if len(hashes) == 0 {
+		return
+	}
+	unscheduled := make(map[string]struct{}, len(hashes))
+	for _, hash := range hashes {
+		unscheduled[strings.ToUpper(hash)] = struct{}{}
+	}
+	defer lockPath(qc.queue.path)()
+	pending := qc.queue.Load()
+	remaining := make([]PendingRemoval, 0, len(pending))
+	for _, entry := range pending {
+		if _, ok := unscheduled[strings.ToUpper(entry.Hash)]; ok && !entry.NotBefore.IsZero() && entry.Attempts == 0 {
+			log.WithFields(log.Fields{
+				"Hash": entry.Hash,
+			}).Info("Scheduled torrent removal has been cancelled")
+			continue
+		}
+		remaining = append(remaining, entry)
+	}
+	if len(remaining) < len(pending) {
+		qc.queue.save(remaining)
+	}
+}
+
+// Retries removal of every queued torrent which is due. The queue stays locked meanwhile so concurrent invocations
+// neither retry the same torrents nor lose each other's entries.
+func (qc *QueuedClient) Drain(ctx context.Context) RemoveResults {
+	defer lockPath(qc.queue.path)()
+	now := time.Now()
+	var hashes []string
+	for _, entry := range qc.queue.Load() {
+		if entry.NotBefore.After(now) {
+			continue
+		}
+		hashes = append(hashes, entry.Hash)
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+	log.WithFields(log.Fields{
+		"Hashes": hashes,
+	}).Info("Rechecking pending torrent removals")
+	results := qc.client.RemoveTorrents(ctx, hashes)
+	qc.queue.update(results)
+	return results
+}
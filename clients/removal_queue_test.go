@@ -0,0 +1,136 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestQueuedClientParksDeferredRemovals(t *testing.T) {
+	hashA := "AAA65110BA16EF7839C27604B41AB083C832D83C"
+	hashB := "BBB65110BA16EF7839C27604B41AB083C832D83C"
+
+	client := new(MockClient)
+	client.On("RemoveTorrents", []string{hashA, hashB}).Return(RemoveResults{
+		{Hash: hashA, Status: StatusDeferred},
+		{Hash: hashB, Status: StatusRemoved},
+	}).Once()
+	client.On("RemoveTorrents", []string{hashA}).Return(RemoveResults{
+		{Hash: hashA, Status: StatusRemoved},
+	}).Once()
+
+	queue := NewRemovalQueue(filepath.Join(t.TempDir(), ".queue", "removals.json"))
+	queuedClient := NewQueuedClient(client, queue)
+
+	queuedClient.RemoveTorrents(context.Background(), []string{hashA, hashB})
+	pending := queue.Load()
+	assert.Len(t, pending, 1)
+	assert.Equal(t, hashA, pending[0].Hash)
+	assert.Equal(t, StatusDeferred, pending[0].Status)
+
+	// The next invocation rechecks the deferred torrent and clears the queue
+	queuedClient.Drain(context.Background())
+	assert.Empty(t, queue.Load())
+
+	mock.AssertExpectationsForObjects(t, client)
+}
+
+func TestRemovalQueueRetriesFailedRemovals(t *testing.T) {
+	hash := "AAA65110BA16EF7839C27604B41AB083C832D83C"
+	queue := NewRemovalQueue(filepath.Join(t.TempDir(), ".queue", "removals.json"))
+
+	queue.Update(RemoveResults{{Hash: hash, Status: StatusFailed, Err: errors.New("connection refused")}})
+	queue.Update(RemoveResults{{Hash: hash, Status: StatusFailed, Err: errors.New("connection reset")}})
+
+	pending := queue.Load()
+	assert.Len(t, pending, 1)
+	assert.Equal(t, StatusFailed, pending[0].Status)
+	assert.Equal(t, 2, pending[0].Attempts)
+	assert.Equal(t, "connection reset", pending[0].LastError)
+
+	// Torrents removed in the meantime settle the queue entry
+	queue.Update(RemoveResults{{Hash: strings.ToLower(hash), Status: StatusNotFound}})
+	assert.Empty(t, queue.Load())
+}
+
+func TestRemovalQueueKeepsEntriesOnDryRun(t *testing.T) {
+	hash := "AAA65110BA16EF7839C27604B41AB083C832D83C"
+	queue := NewRemovalQueue(filepath.Join(t.TempDir(), ".queue", "removals.json"))
+
+	queue.Update(RemoveResults{{Hash: hash, Status: StatusFailed, Err: errors.New("connection refused")}})
+	// Neither dry runs nor skipped removals tried to remove the torrent
+	queue.Update(RemoveResults{{Hash: hash, Status: StatusDryRun}})
+	queue.Update(RemoveResults{{Hash: hash, Status: StatusSkipped}})
+
+	pending := queue.Load()
+	assert.Len(t, pending, 1)
+	assert.Equal(t, StatusFailed, pending[0].Status)
+	assert.Equal(t, 1, pending[0].Attempts)
+}
+
+func TestRemovalQueueKeepsConcurrentUpdates(t *testing.T) {
+	queue := NewRemovalQueue(filepath.Join(t.TempDir(), ".queue", "removals.json"))
+
+	// Every custom script invocation updates the queue from its own process
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			NewRemovalQueue(queue.path).Update(RemoveResults{{Hash: fmt.Sprintf("%040X", i), Status: StatusFailed}})
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, queue.Load(), 20)
+}
+
+func TestQueuedClientDrainsScheduledRemovalsWhenDue(t *testing.T) {
+	dueHash := "AAA65110BA16EF7839C27604B41AB083C832D83C"
+	laterHash := "BBB65110BA16EF7839C27604B41AB083C832D83C"
+
+	client := new(MockClient)
+	client.On("RemoveTorrents", []string{dueHash}).Return(RemoveResults{
+		{Hash: dueHash, Status: StatusRemoved},
+	}).Once()
+
+	queue := NewRemovalQueue(filepath.Join(t.TempDir(), ".queue", "removals.json"))
+	queuedClient := NewQueuedClient(client, queue)
+
+	results := queuedClient.ScheduleRemovals([]string{dueHash}, time.Now().Add(-time.Minute))
+	assert.Equal(t, RemoveResults{{Hash: dueHash, Status: StatusDeferred}}, results)
+	queuedClient.ScheduleRemovals([]string{laterHash}, time.Now().Add(72*time.Hour))
+
+	// Only the torrent whose grace period is over is removed
+	queuedClient.Drain(context.Background())
+	pending := queue.Load()
+	assert.Len(t, pending, 1)
+	assert.Equal(t, laterHash, pending[0].Hash)
+	assert.Equal(t, StatusDeferred, pending[0].Status)
+
+	mock.AssertExpectationsForObjects(t, client)
+}
+
+func TestQueuedClientUnschedulesOnlyScheduledRemovals(t *testing.T) {
+	scheduledHash := "AAA65110BA16EF7839C27604B41AB083C832D83C"
+	failedHash := "BBB65110BA16EF7839C27604B41AB083C832D83C"
+
+	queue := NewRemovalQueue(filepath.Join(t.TempDir(), ".queue", "removals.json"))
+	queuedClient := NewQueuedClient(new(MockClient), queue)
+	queuedClient.ScheduleRemovals([]string{scheduledHash}, time.Now().Add(72*time.Hour))
+	queue.Update(RemoveResults{{Hash: failedHash, Status: StatusFailed, Err: errors.New("connection refused")}})
+
+	queuedClient.UnscheduleRemovals([]string{strings.ToLower(scheduledHash), failedHash})
+
+	pending := queue.Load()
+	assert.Len(t, pending, 1)
+	assert.Equal(t, failedHash, pending[0].Hash)
+}
@@ -0,0 +1,169 @@
+package clients
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Seeding state used to decide whether a torrent may be removed
+type TorrentStats struct {
+	Hash        string
+	Ratio       float64
+	SeedingTime time.Duration
+	Private     bool
+	Trackers    []string
+}
+
+// Implemented by clients able to report seeding state, required by retention rules
+type StatsClient interface {
+	TorrentStats(ctx context.Context, hashes []string) (map[string]TorrentStats, error)
+}
+
+type RetentionRule struct {
+	MinRatio       float64       `yaml:"min_ratio"`
+	MinSeedingTime time.Duration `yaml:"min_seeding_time"`
+}
+
+// Retention rules of a torrent client. Tracker rules replace the client rule for torrents announcing
+// to the tracker host (or its subdomains), the private rule has to be satisfied by private torrents
+// on top of that, e.g. to avoid hit and runs.
+type RetentionPolicy struct {
+	RetentionRule `yaml:",inline"`
+	Private       *RetentionRule           `yaml:"private"`
+	Trackers      map[string]RetentionRule `yaml:"trackers"`
+}
+
+// Wraps a torrent client and defers removal of torrents which don't satisfy retention rules yet
+type RetentionClient struct {
+	client TorrentClient
+	policy RetentionPolicy
+}
+
+func parseRetentionPolicy(config ClientConfig) (*RetentionPolicy, error) {
+	retention, ok := config["retention"]
+	if !ok || retention == nil {
+		return nil, nil
+	}
+	// Nested config values are generic maps, round trip them through YAML to get typed rules
+	yamlBytes, err := yaml.Marshal(retention)
+	if err != nil {
+		return nil, err
+	}
+	var policy RetentionPolicy
+	if err := yaml.Unmarshal(yamlBytes, &policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func NewRetentionClient(client TorrentClient, policy RetentionPolicy) *RetentionClient {
+	return &RetentionClient{
+		client: client,
+		policy: policy,
+	}
+}
+
+func (rc *RetentionClient) Test() bool {
+	return rc.client.Test()
+}
+
+func (rc *RetentionClient) ExistingTorrents(hashes []string) ([]string, error) {
+	return rc.client.ExistingTorrents(hashes)
+}
+
+func (rc *RetentionClient) ListTorrents(ctx context.Context) ([]TorrentContent, error) {
+	return rc.client.ListTorrents(ctx)
+}
+
+func (rc *RetentionClient) RestoreTorrents(ctx context.Context, hashes []string) RemoveResults {
+	return restoreTorrents(ctx, rc.client, hashes)
+}
+
+func (rc *RetentionClient) RouteHash(hash string, downloadClient string) {
+	if router, ok := rc.client.(HashRouter); ok {
+		router.RouteHash(hash, downloadClient)
+	}
+}
+
+func (rc *RetentionClient) RemoveTorrents(ctx context.Context, hashes []string) RemoveResults {
+	if len(hashes) == 0 {
+		return nil
+	}
+	statsClient, ok := rc.client.(StatsClient)
+	if !ok {
+		log.Warn("Torrent client doesn't report seeding stats, retention rules are ignored")
+		return rc.client.RemoveTorrents(ctx, hashes)
+	}
+	stats, err := statsClient.TorrentStats(ctx, hashes)
+	if err != nil {
+		log.WithError(err).Error("Couldn't get torrent seeding stats")
+		return resultsFor(hashes, StatusFailed, err)
+	}
+
+	var eligible, deferred []string
+	for _, hash := range hashes {
+		torrentStats, ok := stats[strings.ToUpper(hash)]
+		// Missing torrents are passed on to be reported as not found
+		if !ok || rc.policy.eligible(torrentStats) {
+			eligible = append(eligible, hash)
+			continue
+		}
+		log.WithFields(log.Fields{
+			"Hash":         hash,
+			"Ratio":        torrentStats.Ratio,
+			"Seeding Time": torrentStats.SeedingTime.String(),
+			"Private":      torrentStats.Private,
+		}).Info("Torrent doesn't satisfy retention rules yet, deferring removal")
+		deferred = append(deferred, hash)
+	}
+
+	results := resultsFor(deferred, StatusDeferred, nil)
+	if len(eligible) > 0 {
+		results = append(results, rc.client.RemoveTorrents(ctx, eligible)...)
+	}
+	return results
+}
+
+func (rp RetentionPolicy) eligible(stats TorrentStats) bool {
+	rule := rp.RetentionRule
+	if trackerRule, ok := rp.trackerRule(stats.Trackers); ok {
+		rule = trackerRule
+	}
+	if !rule.satisfied(stats) {
+		return false
+	}
+	if stats.Private && rp.Private != nil {
+		return rp.Private.satisfied(stats)
+	}
+	return true
+}
+
+func (rp RetentionPolicy) trackerRule(trackers []string) (RetentionRule, bool) {
+	for _, tracker := range trackers {
+		host := trackerHost(tracker)
+		for trackerDomain, rule := range rp.Trackers {
+			trackerDomain = strings.ToLower(trackerDomain)
+			if host == trackerDomain || strings.HasSuffix(host, "."+trackerDomain) {
+				return rule, true
+			}
+		}
+	}
+	return RetentionRule{}, false
+}
+
+func (rr RetentionRule) satisfied(stats TorrentStats) bool {
+	return stats.Ratio >= rr.MinRatio && stats.SeedingTime >= rr.MinSeedingTime
+}
+
+// Extracts the host of a tracker announce url, plain hosts are returned as is
+func trackerHost(tracker string) string {
+	if endpoint, err := url.Parse(tracker); err == nil && endpoint.Hostname() != "" {
+		return strings.ToLower(endpoint.Hostname())
+	}
+	return strings.ToLower(tracker)
+}
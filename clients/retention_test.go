@@ -0,0 +1,81 @@
+package clients
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gopkg.in/yaml.v3"
+)
+
+type MockStatsClient struct {
+	MockClient
+}
+
+func (m *MockStatsClient) TorrentStats(ctx context.Context, hashes []string) (map[string]TorrentStats, error) {
+	args := m.Called(hashes)
+	return args.Get(0).(map[string]TorrentStats), args.Error(1)
+}
+
+func TestParseRetentionPolicy(t *testing.T) {
+	var config ClientConfig
+	err := yaml.Unmarshal([]byte(`
+host: http://localhost:8080
+retention:
+  min_ratio: 1
+  min_seeding_time: 24h
+  private:
+    min_seeding_time: 240h
+  trackers:
+    tracker.example.org:
+      min_ratio: 2
+`), &config)
+	assert.NoError(t, err)
+
+	policy, err := parseRetentionPolicy(config)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &RetentionPolicy{
+		RetentionRule: RetentionRule{MinRatio: 1, MinSeedingTime: 24 * time.Hour},
+		Private:       &RetentionRule{MinSeedingTime: 240 * time.Hour},
+		Trackers:      map[string]RetentionRule{"tracker.example.org": {MinRatio: 2}},
+	}, policy)
+}
+
+func TestRetentionEligibility(t *testing.T) {
+	policy := RetentionPolicy{
+		RetentionRule: RetentionRule{MinRatio: 1},
+		Private:       &RetentionRule{MinSeedingTime: 72 * time.Hour},
+		Trackers:      map[string]RetentionRule{"example.org": {MinRatio: 2}},
+	}
+
+	assert.True(t, policy.eligible(TorrentStats{Ratio: 1.2}))
+	assert.False(t, policy.eligible(TorrentStats{Ratio: 0.5}))
+	assert.False(t, policy.eligible(TorrentStats{Ratio: 1.2, Trackers: []string{"https://tracker.example.org/announce?passkey=x"}}))
+	assert.True(t, policy.eligible(TorrentStats{Ratio: 2.1, Trackers: []string{"https://tracker.example.org/announce?passkey=x"}}))
+	assert.False(t, policy.eligible(TorrentStats{Ratio: 1.2, Private: true, SeedingTime: time.Hour}))
+	assert.True(t, policy.eligible(TorrentStats{Ratio: 1.2, Private: true, SeedingTime: 100 * time.Hour}))
+}
+
+func TestRetentionClientDefersRemoval(t *testing.T) {
+	hashA := "AAA65110BA16EF7839C27604B41AB083C832D83C"
+	hashB := "BBB65110BA16EF7839C27604B41AB083C832D83C"
+
+	client := new(MockStatsClient)
+	client.On("TorrentStats", []string{hashA, hashB}).Return(map[string]TorrentStats{
+		hashA: {Hash: hashA, Ratio: 0.3},
+		hashB: {Hash: hashB, Ratio: 1.5},
+	}, nil)
+	client.On("RemoveTorrents", []string{hashB}).Return(resultsFor([]string{hashB}, StatusRemoved, nil))
+
+	retentionClient := NewRetentionClient(client, RetentionPolicy{RetentionRule: RetentionRule{MinRatio: 1}})
+	results := retentionClient.RemoveTorrents(context.Background(), []string{hashA, hashB})
+
+	mock.AssertExpectationsForObjects(t, client)
+	assert.Equal(t, RemoveResults{
+		{Hash: hashA, Status: StatusDeferred},
+		{Hash: hashB, Status: StatusRemoved},
+	}, results)
+}
@@ -1,9 +1,14 @@
 package clients
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"path"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/kolo/xmlrpc"
@@ -12,9 +17,14 @@ import (
 
 type RtorrentClient struct {
 	xmlrpcClient *xmlrpc.Client
+	sharedData   SharedDataPolicy
 }
 
-func NewRtorrentClient(config ClientConfig) TorrentClient {
+func NewRtorrentClient(config ClientConfig) (TorrentClient, error) {
+	endpoint, err := clientEndpoint(config)
+	if err != nil {
+		return nil, err
+	}
 	defaultTransport := http.DefaultTransport.(*http.Transport)
 	transport := &http.Transport{
 		Proxy: defaultTransport.Proxy,
@@ -28,12 +38,11 @@ func NewRtorrentClient(config ClientConfig) TorrentClient {
 		TLSHandshakeTimeout:   defaultTransport.TLSHandshakeTimeout,
 		ExpectContinueTimeout: defaultTransport.ExpectContinueTimeout,
 	}
-	xmlrpcClient, err := xmlrpc.NewClient(config["host"].(string), transport)
+	xmlrpcClient, err := xmlrpc.NewClient(endpoint.String(), transport)
 	if err != nil {
-		log.Error("Coulnd't initialize rtorrent client")
-		return nil
+		return nil, err
 	}
-	return &RtorrentClient{xmlrpcClient: xmlrpcClient}
+	return &RtorrentClient{xmlrpcClient: xmlrpcClient, sharedData: sharedDataPolicy(config)}, nil
 }
 
 func (rc RtorrentClient) Test() bool {
@@ -52,79 +61,324 @@ func (rc RtorrentClient) Test() bool {
 	return false
 }
 
-func (rc RtorrentClient) RemoveTorrents(hashes []string) {
+func (rc RtorrentClient) RemoveTorrents(ctx context.Context, hashes []string) RemoveResults {
 	if len(hashes) == 0 {
-		return
+		return nil
 	}
 	log.WithFields(log.Fields{
 		"Hashes": hashes,
 	}).Info("Requesting torrent files removal")
-	for _, hash := range hashes {
-		for attempt := 1; attempt <= 3; attempt++ {
-			var response any
-			deleteParams := []map[string]any{
-				{
+
+	contents, err := rc.contents()
+	if err != nil {
+		log.WithError(err).Error("Couldn't list rTorrent downloads")
+		return resultsFor(hashes, StatusFailed, err)
+	}
+	downloadList := make([]string, len(contents))
+	for i, content := range contents {
+		downloadList[i] = content.Hash
+	}
+	existing := matchHashes(hashes, downloadList)
+	results := resultsFor(removeHashes(hashes, existing), StatusNotFound, nil)
+
+	withData, withoutData, skipped := planRemoval(rc.sharedData, existing, contents, rc.torrentFiles)
+	results = append(results, resultsFor(skipped, StatusSkipped, nil)...)
+	for _, hash := range withData {
+		results = append(results, rc.removeResult(hash, rc.removeTorrent(ctx, hash, true)))
+	}
+	for _, hash := range withoutData {
+		results = append(results, rc.removeResult(hash, rc.removeTorrent(ctx, hash, false)))
+	}
+	return results
+}
+
+func (rc RtorrentClient) removeResult(hash string, err error) RemoveResult {
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"Hash": hash,
+		}).Error("Couldn't remove torrent")
+		return RemoveResult{Hash: hash, Status: StatusFailed, Err: err}
+	}
+	return RemoveResult{Hash: hash, Status: StatusRemoved}
+}
+
+// Erases the torrent, optionally along with its data, retrying a few times
+func (rc RtorrentClient) removeTorrent(ctx context.Context, hash string, deleteData bool) error {
+	var lastErr error
+	for attempt := 1; attempt <= 3; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+			}
+		}
+		var response any
+		var deleteParams []map[string]any
+		if deleteData {
+			deleteParams = append(deleteParams,
+				map[string]any{
 					"methodName": "d.custom5.set",
 					"params":     []any{hash, "1"},
 				},
-				{
+				map[string]any{
 					"methodName": "d.delete_tied",
 					"params":     []any{hash},
 				},
-				{
-					"methodName": "d.erase",
-					"params":     []any{hash},
-				},
-			}
-			err := rc.xmlrpcClient.Call("system.multicall", deleteParams, &response)
+			)
+		}
+		deleteParams = append(deleteParams, map[string]any{
+			"methodName": "d.erase",
+			"params":     []any{hash},
+		})
+		err := rc.xmlrpcClient.Call("system.multicall", deleteParams, &response)
 
-			if err != nil {
-				log.WithError(err).Error("Couldn't run erase call")
-				time.Sleep(time.Second)
-				continue
-			}
+		if err != nil {
+			log.WithError(err).Error("Couldn't run erase call")
+			lastErr = err
+			continue
+		}
 
-			responseSlice, ok := response.([]any)
-			if !ok {
-				log.WithFields(log.Fields{
-					"Error responses": response,
-					"Hash":            hash,
-				}).Error("Unknown response type")
-				time.Sleep(time.Second)
-				continue
-			}
+		responseSlice, ok := response.([]any)
+		if !ok {
+			log.WithFields(log.Fields{
+				"Error responses": response,
+				"Hash":            hash,
+			}).Error("Unknown response type")
+			lastErr = errors.New("unknown rTorrent response type")
+			continue
+		}
 
-			var successResponses []interface{}
-			var errorResponses []interface{}
-
-			for _, responseMap := range responseSlice {
-				if responseMapSlice, ok := responseMap.([]interface{}); ok {
-					successResponse, ok := responseMapSlice[0].(string)
-					if ok {
-						successResponses = append(successResponses, successResponse)
-					}
-				} else if responseMapMap, ok := responseMap.(map[string]interface{}); ok {
-					errorResponses = append(errorResponses, responseMapMap)
+		var successResponses []interface{}
+		var errorResponses []interface{}
+
+		for _, responseMap := range responseSlice {
+			if responseMapSlice, ok := responseMap.([]interface{}); ok {
+				successResponse, ok := responseMapSlice[0].(string)
+				if ok {
+					successResponses = append(successResponses, successResponse)
 				}
+			} else if responseMapMap, ok := responseMap.(map[string]interface{}); ok {
+				errorResponses = append(errorResponses, responseMapMap)
 			}
+		}
 
-			if len(errorResponses) > 0 {
-				log.WithFields(log.Fields{
-					"Attempt":         attempt,
-					"Error responses": errorResponses,
-					"Hash":            hash,
-				}).Debug("Couldn't remove torrents")
-				time.Sleep(time.Second)
-				continue
-			}
+		if len(errorResponses) > 0 {
+			log.WithFields(log.Fields{
+				"Attempt":         attempt,
+				"Error responses": errorResponses,
+				"Hash":            hash,
+			}).Debug("Couldn't remove torrents")
+			lastErr = fmt.Errorf("rTorrent erase failed: %v", errorResponses)
+			continue
+		}
+
+		if len(successResponses) > 0 {
+			log.WithFields(log.Fields{
+				"Hash":    hash,
+				"Attempt": attempt,
+			}).Info("Torrent has been removed")
+			return nil
+		}
+		lastErr = errors.New("empty rTorrent erase response")
+	}
+	return lastErr
+}
+
+// Stops the torrents and replaces their ruTorrent label, the previous label is kept in a custom field
+func (rc RtorrentClient) QuarantineTorrents(ctx context.Context, hashes []string) RemoveResults {
+	if len(hashes) == 0 {
+		return nil
+	}
+	downloadList, err := rc.downloadList()
+	if err != nil {
+		log.WithError(err).Error("Couldn't list rTorrent downloads")
+		return resultsFor(hashes, StatusFailed, err)
+	}
+	existing := matchHashes(hashes, downloadList)
+	results := resultsFor(removeHashes(hashes, existing), StatusNotFound, nil)
+	for _, hash := range existing {
+		results = append(results, rc.quarantineTorrent(hash))
+	}
+	return results
+}
+
+func (rc RtorrentClient) quarantineTorrent(hash string) RemoveResult {
+	var label string
+	var response any
+	err := rc.xmlrpcClient.Call("d.custom1", []any{hash}, &label)
+	if err == nil && label != QuarantineLabel {
+		err = rc.xmlrpcClient.Call("d.custom.set", []any{hash, "arrcoon_label", label}, &response)
+	}
+	if err == nil {
+		err = rc.xmlrpcClient.Call("d.stop", []any{hash}, &response)
+	}
+	if err == nil {
+		err = rc.xmlrpcClient.Call("d.custom1.set", []any{hash, QuarantineLabel}, &response)
+	}
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"Hash": hash,
+		}).Error("Couldn't quarantine torrent")
+		return RemoveResult{Hash: hash, Status: StatusFailed, Err: err}
+	}
+	return RemoveResult{Hash: hash, Status: StatusQuarantined}
+}
 
-			if len(successResponses) > 0 {
-				log.WithFields(log.Fields{
-					"Hash":    hash,
-					"Attempt": attempt,
-				}).Info("Torrent has been removed")
-				break
+// Puts the previous ruTorrent label back and starts the torrents
+func (rc RtorrentClient) RestoreTorrents(ctx context.Context, hashes []string) RemoveResults {
+	if len(hashes) == 0 {
+		return nil
+	}
+	downloadList, err := rc.downloadList()
+	if err != nil {
+		log.WithError(err).Error("Couldn't list rTorrent downloads")
+		return resultsFor(hashes, StatusFailed, err)
+	}
+	existing := matchHashes(hashes, downloadList)
+	results := resultsFor(removeHashes(hashes, existing), StatusNotFound, nil)
+	for _, hash := range existing {
+		results = append(results, rc.restoreTorrent(hash))
+	}
+	return results
+}
+
+func (rc RtorrentClient) restoreTorrent(hash string) RemoveResult {
+	var label, previousLabel string
+	var response any
+	err := rc.xmlrpcClient.Call("d.custom1", []any{hash}, &label)
+	if err == nil && label == QuarantineLabel {
+		err = rc.xmlrpcClient.Call("d.custom", []any{hash, "arrcoon_label"}, &previousLabel)
+		if err == nil {
+			err = rc.xmlrpcClient.Call("d.custom1.set", []any{hash, previousLabel}, &response)
+		}
+	}
+	if err == nil {
+		err = rc.xmlrpcClient.Call("d.start", []any{hash}, &response)
+	}
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"Hash": hash,
+		}).Error("Couldn't restore torrent")
+		return RemoveResult{Hash: hash, Status: StatusFailed, Err: err}
+	}
+	return RemoveResult{Hash: hash, Status: StatusRestored}
+}
+
+func (rc RtorrentClient) downloadList() ([]string, error) {
+	var downloadList []string
+	err := rc.xmlrpcClient.Call("download_list", []any{"", "main"}, &downloadList)
+	return downloadList, err
+}
+
+// File paths are relative to the torrent root folder, or to the download directory of single file torrents
+func (rc RtorrentClient) torrentFiles(hash string) ([]string, error) {
+	var directory string
+	if err := rc.xmlrpcClient.Call("d.directory_base", []any{hash}, &directory); err != nil {
+		return nil, err
+	}
+	var response [][]any
+	if err := rc.xmlrpcClient.Call("f.multicall", []any{hash, "", "f.path="}, &response); err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(response))
+	for _, file := range response {
+		if len(file) == 0 {
+			continue
+		}
+		filePath, _ := file[0].(string)
+		paths = append(paths, path.Join(directory, filePath))
+	}
+	return paths, nil
+}
+
+// Lists hashes and data locations of all downloads
+func (rc RtorrentClient) contents() ([]TorrentContent, error) {
+	var response [][]any
+	err := rc.xmlrpcClient.Call("d.multicall2", []any{"", "main", "d.hash=", "d.base_path="}, &response)
+	if err != nil {
+		return nil, err
+	}
+	contents := make([]TorrentContent, 0, len(response))
+	for _, download := range response {
+		if len(download) < 2 {
+			continue
+		}
+		hash, _ := download[0].(string)
+		basePath, _ := download[1].(string)
+		contents = append(contents, TorrentContent{Hash: hash, Path: basePath})
+	}
+	return contents, nil
+}
+
+func (rc RtorrentClient) ListTorrents(ctx context.Context) ([]TorrentContent, error) {
+	return rc.contents()
+}
+
+func (rc RtorrentClient) ExistingTorrents(hashes []string) ([]string, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	downloadList, err := rc.downloadList()
+	if err != nil {
+		return nil, err
+	}
+	return matchHashes(hashes, downloadList), nil
+}
+
+func (rc RtorrentClient) TorrentStats(ctx context.Context, hashes []string) (map[string]TorrentStats, error) {
+	var response [][]any
+	err := rc.xmlrpcClient.Call("d.multicall2", []any{"", "main", "d.hash=", "d.ratio=", "d.timestamp.finished=", "d.is_private="}, &response)
+	if err != nil {
+		return nil, err
+	}
+	requested := make(map[string]struct{}, len(hashes))
+	for _, hash := range hashes {
+		requested[strings.ToUpper(hash)] = struct{}{}
+	}
+	stats := make(map[string]TorrentStats)
+	for _, download := range response {
+		if len(download) < 4 {
+			continue
+		}
+		hash, _ := download[0].(string)
+		if _, ok := requested[strings.ToUpper(hash)]; !ok {
+			continue
+		}
+		torrentStats := TorrentStats{
+			Hash: hash,
+			// rTorrent reports ratio in permille
+			Ratio:   float64(xmlrpcInt(download[1])) / 1000,
+			Private: xmlrpcInt(download[3]) == 1,
+		}
+		if finished := xmlrpcInt(download[2]); finished > 0 {
+			torrentStats.SeedingTime = time.Since(time.Unix(finished, 0))
+		}
+		var trackers [][]any
+		err := rc.xmlrpcClient.Call("t.multicall", []any{hash, "", "t.url="}, &trackers)
+		if err != nil {
+			return nil, err
+		}
+		for _, tracker := range trackers {
+			if len(tracker) > 0 {
+				if trackerUrl, ok := tracker[0].(string); ok {
+					torrentStats.Trackers = append(torrentStats.Trackers, trackerUrl)
+				}
 			}
 		}
+		stats[strings.ToUpper(hash)] = torrentStats
+	}
+	return stats, nil
+}
+
+func xmlrpcInt(value any) int64 {
+	switch number := value.(type) {
+	case int64:
+		return number
+	case int:
+		return int64(number)
+	case float64:
+		return int64(number)
 	}
+	return 0
 }
@@ -0,0 +1,151 @@
+package clients
+
+import (
+	"path"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// What to do with a torrent whose data is used by another torrent in the client, e.g. a cross-seed
+type SharedDataPolicy string
+
+const (
+	// Remove the torrent entry but keep the data on disk
+	SharedDataKeep SharedDataPolicy = "keep"
+	// Don't remove the torrent at all
+	SharedDataSkip SharedDataPolicy = "skip"
+	// Remove the torrent with its data without checking other torrents
+	SharedDataDelete SharedDataPolicy = "delete"
+)
+
+// Location of the torrent data in the client
+type TorrentContent struct {
+	Hash string
+	Path string
+	// Only filled by clients listing files along with the torrents
+	Files []string
+}
+
+func sharedDataPolicy(config ClientConfig) SharedDataPolicy {
+	policy, _ := config["shared_data"].(string)
+	switch SharedDataPolicy(policy) {
+	case SharedDataSkip, SharedDataDelete:
+		return SharedDataPolicy(policy)
+	case SharedDataKeep, "":
+		return SharedDataKeep
+	default:
+		log.WithFields(log.Fields{
+			"shared_data": policy,
+		}).Warn("Unknown shared data policy, keeping shared data")
+		return SharedDataKeep
+	}
+}
+
+// Lists absolute paths of the files of a torrent
+type torrentFiles func(hash string) ([]string, error)
+
+// Returns the subset of hashes whose data overlaps with a torrent which isn't being removed.
+// Torrents with overlapping content paths are compared by their files when a file lister is given.
+func sharedDataHashes(hashes []string, contents []TorrentContent, files torrentFiles) map[string]bool {
+	removed := make(map[string]struct{}, len(hashes))
+	for _, hash := range hashes {
+		removed[strings.ToUpper(hash)] = struct{}{}
+	}
+	var removedContents, keptContents []TorrentContent
+	for _, content := range contents {
+		if content.Path == "" {
+			continue
+		}
+		if _, ok := removed[strings.ToUpper(content.Hash)]; ok {
+			removedContents = append(removedContents, content)
+		} else {
+			keptContents = append(keptContents, content)
+		}
+	}
+
+	fileSets := make(map[string]map[string]struct{})
+	fileSet := func(hash string) (map[string]struct{}, bool) {
+		if set, ok := fileSets[strings.ToUpper(hash)]; ok {
+			return set, set != nil
+		}
+		paths, err := files(hash)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"Hash": hash,
+			}).Warn("Couldn't list torrent files, comparing content paths only")
+		}
+		var set map[string]struct{}
+		if err == nil && len(paths) > 0 {
+			set = make(map[string]struct{}, len(paths))
+			for _, filePath := range paths {
+				set[cleanPath(filePath)] = struct{}{}
+			}
+		}
+		fileSets[strings.ToUpper(hash)] = set
+		return set, set != nil
+	}
+	// Overlapping content paths don't mean shared files, e.g. torrents without a root folder in the same directory
+	sharesFiles := func(a string, b string) bool {
+		if files == nil {
+			return true
+		}
+		aFiles, aOk := fileSet(a)
+		bFiles, bOk := fileSet(b)
+		if !aOk || !bOk {
+			return true
+		}
+		for filePath := range aFiles {
+			if _, ok := bFiles[filePath]; ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	shared := make(map[string]bool)
+	for _, removedContent := range removedContents {
+		for _, keptContent := range keptContents {
+			if pathsOverlap(removedContent.Path, keptContent.Path) && sharesFiles(removedContent.Hash, keptContent.Hash) {
+				log.WithFields(log.Fields{
+					"Hash":         removedContent.Hash,
+					"Shared With":  keptContent.Hash,
+					"Content Path": removedContent.Path,
+				}).Info("Torrent shares data with another torrent")
+				shared[strings.ToUpper(removedContent.Hash)] = true
+				break
+			}
+		}
+	}
+	return shared
+}
+
+// Paths overlap when they're equal or one of them contains the other
+func pathsOverlap(a string, b string) bool {
+	a = cleanPath(a)
+	b = cleanPath(b)
+	return a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
+
+func cleanPath(p string) string {
+	return path.Clean(strings.ReplaceAll(p, "\\", "/"))
+}
+
+// Splits existing hashes into removals with data, removals keeping the data and skipped ones
+func planRemoval(policy SharedDataPolicy, existing []string, contents []TorrentContent, files torrentFiles) (withData []string, withoutData []string, skipped []string) {
+	if policy == SharedDataDelete {
+		return existing, nil, nil
+	}
+	shared := sharedDataHashes(existing, contents, files)
+	for _, hash := range existing {
+		switch {
+		case !shared[strings.ToUpper(hash)]:
+			withData = append(withData, hash)
+		case policy == SharedDataSkip:
+			skipped = append(skipped, hash)
+		default:
+			withoutData = append(withoutData, hash)
+		}
+	}
+	return withData, withoutData, skipped
+}
@@ -0,0 +1,78 @@
+package clients
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanRemoval(t *testing.T) {
+	contents := []TorrentContent{
+		{Hash: "AAA", Path: "/data/Show.S01"},
+		{Hash: "BBB", Path: "/data/Show.S01/Show.S01E01.mkv"},
+		{Hash: "CCC", Path: "/data/Movie.2024"},
+		{Hash: "DDD", Path: "/data/Other.Movie"},
+		{Hash: "EEE", Path: "/data/Other.Movie"},
+	}
+
+	withData, withoutData, skipped := planRemoval(SharedDataKeep, []string{"aaa", "CCC", "DDD", "EEE"}, contents, nil)
+	assert.Equal(t, []string{"CCC", "DDD", "EEE"}, withData)
+	assert.Equal(t, []string{"aaa"}, withoutData)
+	assert.Nil(t, skipped)
+
+	withData, withoutData, skipped = planRemoval(SharedDataSkip, []string{"AAA", "CCC"}, contents, nil)
+	assert.Equal(t, []string{"CCC"}, withData)
+	assert.Nil(t, withoutData)
+	assert.Equal(t, []string{"AAA"}, skipped)
+
+	withData, withoutData, skipped = planRemoval(SharedDataDelete, []string{"AAA", "CCC"}, contents, nil)
+	assert.Equal(t, []string{"AAA", "CCC"}, withData)
+	assert.Nil(t, withoutData)
+	assert.Nil(t, skipped)
+}
+
+func TestPlanRemovalComparesFiles(t *testing.T) {
+	files := map[string][]string{
+		// Torrents without a root folder report the save path as their content path
+		"AAA": {"/data/Show.S01E01.mkv"},
+		"BBB": {"/data/Movie.2024.mkv"},
+		"CCC": {"/data/Show.S02/Show.S02E01.mkv", "/data/Show.S02/Show.S02E02.mkv"},
+		"DDD": {"/data/Show.S02/Show.S02E02.mkv"},
+		"EEE": {"/data/Show.S02/Show.S02E03.mkv"},
+	}
+	lister := func(hash string) ([]string, error) {
+		return files[hash], nil
+	}
+
+	// Sharing a directory isn't sharing data
+	withData, withoutData, _ := planRemoval(SharedDataKeep, []string{"AAA"}, []TorrentContent{
+		{Hash: "AAA", Path: "/data"},
+		{Hash: "BBB", Path: "/data"},
+	}, lister)
+	assert.Equal(t, []string{"AAA"}, withData)
+	assert.Nil(t, withoutData)
+
+	// Files inside the directory of another torrent are shared, from either side
+	contents := []TorrentContent{
+		{Hash: "CCC", Path: "/data/Show.S02"},
+		{Hash: "DDD", Path: "/data/Show.S02/Show.S02E02.mkv"},
+		{Hash: "EEE", Path: "/data/Show.S02/Show.S02E03.mkv"},
+	}
+	withData, withoutData, _ = planRemoval(SharedDataKeep, []string{"CCC"}, contents, lister)
+	assert.Nil(t, withData)
+	assert.Equal(t, []string{"CCC"}, withoutData)
+	withData, withoutData, _ = planRemoval(SharedDataKeep, []string{"DDD", "EEE"}, contents, lister)
+	assert.Equal(t, []string{"EEE"}, withData)
+	assert.Equal(t, []string{"DDD"}, withoutData)
+
+	// Without file lists overlapping content paths are shared
+	withData, withoutData, _ = planRemoval(SharedDataKeep, []string{"AAA"}, []TorrentContent{
+		{Hash: "AAA", Path: "/data"},
+		{Hash: "BBB", Path: "/data"},
+	}, func(hash string) ([]string, error) {
+		return nil, errors.New("connection refused")
+	})
+	assert.Nil(t, withData)
+	assert.Equal(t, []string{"AAA"}, withoutData)
+}
@@ -2,7 +2,9 @@ package clients
 
 import (
 	"context"
-	"net/url"
+	"path"
+	"slices"
+	"strings"
 
 	"github.com/hekmon/transmissionrpc/v3"
 	log "github.com/sirupsen/logrus"
@@ -10,12 +12,17 @@ import (
 
 type TransmissionClient struct {
 	transmissionClient TransmissionRPCInterface
+	sharedData         SharedDataPolicy
 }
 
 type TransmissionRPCInterface interface {
 	RPCVersion(ctx context.Context) (ok bool, serverVersion int64, serverMinimumVersion int64, err error)
 	TorrentGetAllForHashes(ctx context.Context, hashes []string) (torrents []transmissionrpc.Torrent, err error)
+	TorrentGet(ctx context.Context, fields []string, ids []int64) (torrents []transmissionrpc.Torrent, err error)
 	TorrentRemove(ctx context.Context, payload transmissionrpc.TorrentRemovePayload) (err error)
+	TorrentStopIDs(ctx context.Context, ids []int64) (err error)
+	TorrentStartIDs(ctx context.Context, ids []int64) (err error)
+	TorrentSet(ctx context.Context, payload transmissionrpc.TorrentSetPayload) (err error)
 }
 
 type TransmissionRPC struct {
@@ -30,20 +37,36 @@ func (trpcw *TransmissionRPC) TorrentGetAllForHashes(ctx context.Context, hashes
 	return trpcw.transmissionClient.TorrentGetAllForHashes(ctx, hashes)
 }
 
+func (trpcw *TransmissionRPC) TorrentGet(ctx context.Context, fields []string, ids []int64) (torrents []transmissionrpc.Torrent, err error) {
+	return trpcw.transmissionClient.TorrentGet(ctx, fields, ids)
+}
+
 func (trpcw *TransmissionRPC) TorrentRemove(ctx context.Context, payload transmissionrpc.TorrentRemovePayload) (err error) {
 	return trpcw.transmissionClient.TorrentRemove(ctx, payload)
 }
 
-func NewTransmissionClient(config ClientConfig) TorrentClient {
-	endpoint, err := url.Parse(config["host"].(string))
+func (trpcw *TransmissionRPC) TorrentStopIDs(ctx context.Context, ids []int64) (err error) {
+	return trpcw.transmissionClient.TorrentStopIDs(ctx, ids)
+}
+
+func (trpcw *TransmissionRPC) TorrentStartIDs(ctx context.Context, ids []int64) (err error) {
+	return trpcw.transmissionClient.TorrentStartIDs(ctx, ids)
+}
+
+func (trpcw *TransmissionRPC) TorrentSet(ctx context.Context, payload transmissionrpc.TorrentSetPayload) (err error) {
+	return trpcw.transmissionClient.TorrentSet(ctx, payload)
+}
+
+func NewTransmissionClient(config ClientConfig) (TorrentClient, error) {
+	endpoint, err := clientEndpoint(config)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	tbt, err := transmissionrpc.New(endpoint, nil)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	return &TransmissionClient{transmissionClient: &TransmissionRPC{tbt}}
+	return &TransmissionClient{transmissionClient: &TransmissionRPC{tbt}, sharedData: sharedDataPolicy(config)}, nil
 }
 
 func (tc TransmissionClient) Test() bool {
@@ -68,10 +91,9 @@ func (tc TransmissionClient) Test() bool {
 	return true
 }
 
-func (tc TransmissionClient) RemoveTorrents(hashes []string) {
-	ctx := context.Background()
+func (tc TransmissionClient) RemoveTorrents(ctx context.Context, hashes []string) RemoveResults {
 	if len(hashes) == 0 {
-		return
+		return nil
 	}
 	torrents, err := tc.transmissionClient.TorrentGetAllForHashes(ctx, hashes)
 
@@ -81,27 +103,226 @@ func (tc TransmissionClient) RemoveTorrents(hashes []string) {
 
 	if err != nil {
 		log.WithError(err).Error("Could torrents hash data")
-		return
+		return resultsFor(hashes, StatusFailed, err)
 	}
 
+	torrentsByHash := make(map[string]transmissionrpc.Torrent, len(torrents))
+	var found []string
 	for _, torrent := range torrents {
-		payload := transmissionrpc.TorrentRemovePayload{
-			IDs:             []int64{*torrent.ID},
-			DeleteLocalData: true,
+		if torrent.HashString != nil && torrent.ID != nil {
+			torrentsByHash[strings.ToUpper(*torrent.HashString)] = torrent
+			found = append(found, *torrent.HashString)
 		}
-		err := tc.transmissionClient.TorrentRemove(ctx, payload)
+	}
+	existing := matchHashes(hashes, found)
+	results := resultsFor(removeHashes(hashes, existing), StatusNotFound, nil)
+
+	var contents []TorrentContent
+	if tc.sharedData != SharedDataDelete {
+		contents, err = tc.contents(ctx)
 		if err != nil {
-			log.
-				WithFields(log.Fields{
-					"Torrent hash": torrent.HashString,
-					"Torrent ID":   torrent.ID,
-				}).
-				WithError(err).
-				Error("Couldn't remove torrent")
+			log.WithError(err).Error("Couldn't list transmission torrents")
+			return append(results, resultsFor(existing, StatusFailed, err)...)
 		}
-		log.WithFields(log.Fields{
-			"Torrent hash": torrent.HashString,
-			"Torrent ID":   torrent.ID,
-		}).Info("Torrent has been removed")
 	}
+	// File lists come along with the contents
+	files := make(map[string][]string, len(contents))
+	for _, content := range contents {
+		files[strings.ToUpper(content.Hash)] = content.Files
+	}
+	withData, withoutData, skipped := planRemoval(tc.sharedData, existing, contents, func(hash string) ([]string, error) {
+		return files[strings.ToUpper(hash)], nil
+	})
+	results = append(results, resultsFor(skipped, StatusSkipped, nil)...)
+	for _, hash := range withData {
+		results = append(results, tc.removeTorrent(ctx, hash, torrentsByHash[strings.ToUpper(hash)], true))
+	}
+	for _, hash := range withoutData {
+		results = append(results, tc.removeTorrent(ctx, hash, torrentsByHash[strings.ToUpper(hash)], false))
+	}
+	return results
+}
+
+func (tc TransmissionClient) removeTorrent(ctx context.Context, hash string, torrent transmissionrpc.Torrent, deleteLocalData bool) RemoveResult {
+	payload := transmissionrpc.TorrentRemovePayload{
+		IDs:             []int64{*torrent.ID},
+		DeleteLocalData: deleteLocalData,
+	}
+	err := tc.transmissionClient.TorrentRemove(ctx, payload)
+	if err != nil {
+		log.
+			WithFields(log.Fields{
+				"Torrent hash": torrent.HashString,
+				"Torrent ID":   torrent.ID,
+			}).
+			WithError(err).
+			Error("Couldn't remove torrent")
+		return RemoveResult{Hash: hash, Status: StatusFailed, Err: err}
+	}
+	log.WithFields(log.Fields{
+		"Torrent hash":      torrent.HashString,
+		"Torrent ID":        torrent.ID,
+		"Delete local data": deleteLocalData,
+	}).Info("Torrent has been removed")
+	return RemoveResult{Hash: hash, Status: StatusRemoved}
+}
+
+// Stops the torrents and adds the quarantine label next to their labels
+func (tc TransmissionClient) QuarantineTorrents(ctx context.Context, hashes []string) RemoveResults {
+	if len(hashes) == 0 {
+		return nil
+	}
+	torrents, err := tc.transmissionClient.TorrentGetAllForHashes(ctx, hashes)
+	if err != nil {
+		log.WithError(err).Error("Couldn't get transmission torrents")
+		return resultsFor(hashes, StatusFailed, err)
+	}
+	var found []string
+	var results RemoveResults
+	for _, torrent := range torrents {
+		if torrent.HashString == nil || torrent.ID == nil {
+			continue
+		}
+		found = append(found, *torrent.HashString)
+		results = append(results, tc.quarantineTorrent(ctx, torrent))
+	}
+	return append(results, resultsFor(removeHashes(hashes, found), StatusNotFound, nil)...)
+}
+
+func (tc TransmissionClient) quarantineTorrent(ctx context.Context, torrent transmissionrpc.Torrent) RemoveResult {
+	hash := *torrent.HashString
+	err := tc.transmissionClient.TorrentStopIDs(ctx, []int64{*torrent.ID})
+	if err == nil && !slices.Contains(torrent.Labels, QuarantineLabel) {
+		err = tc.transmissionClient.TorrentSet(ctx, transmissionrpc.TorrentSetPayload{
+			IDs:    []int64{*torrent.ID},
+			Labels: append(slices.Clone(torrent.Labels), QuarantineLabel),
+		})
+	}
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"Hash": hash,
+		}).Error("Couldn't quarantine torrent")
+		return RemoveResult{Hash: hash, Status: StatusFailed, Err: err}
+	}
+	return RemoveResult{Hash: hash, Status: StatusQuarantined}
+}
+
+// Starts the torrents and drops the quarantine label
+func (tc TransmissionClient) RestoreTorrents(ctx context.Context, hashes []string) RemoveResults {
+	if len(hashes) == 0 {
+		return nil
+	}
+	torrents, err := tc.transmissionClient.TorrentGetAllForHashes(ctx, hashes)
+	if err != nil {
+		log.WithError(err).Error("Couldn't get transmission torrents")
+		return resultsFor(hashes, StatusFailed, err)
+	}
+	var found []string
+	var results RemoveResults
+	for _, torrent := range torrents {
+		if torrent.HashString == nil || torrent.ID == nil {
+			continue
+		}
+		found = append(found, *torrent.HashString)
+		results = append(results, tc.restoreTorrent(ctx, torrent))
+	}
+	return append(results, resultsFor(removeHashes(hashes, found), StatusNotFound, nil)...)
+}
+
+func (tc TransmissionClient) restoreTorrent(ctx context.Context, torrent transmissionrpc.Torrent) RemoveResult {
+	hash := *torrent.HashString
+	var err error
+	if slices.Contains(torrent.Labels, QuarantineLabel) {
+		labels := slices.DeleteFunc(slices.Clone(torrent.Labels), func(label string) bool {
+			return label == QuarantineLabel
+		})
+		err = tc.transmissionClient.TorrentSet(ctx, transmissionrpc.TorrentSetPayload{
+			IDs:    []int64{*torrent.ID},
+			Labels: labels,
+		})
+	}
+	if err == nil {
+		err = tc.transmissionClient.TorrentStartIDs(ctx, []int64{*torrent.ID})
+	}
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"Hash": hash,
+		}).Error("Couldn't restore torrent")
+		return RemoveResult{Hash: hash, Status: StatusFailed, Err: err}
+	}
+	return RemoveResult{Hash: hash, Status: StatusRestored}
+}
+
+// Lists hashes, data locations and files of all torrents
+func (tc TransmissionClient) contents(ctx context.Context) ([]TorrentContent, error) {
+	torrents, err := tc.transmissionClient.TorrentGet(ctx, []string{"hashString", "downloadDir", "name", "files"}, nil)
+	if err != nil {
+		return nil, err
+	}
+	contents := make([]TorrentContent, 0, len(torrents))
+	for _, torrent := range torrents {
+		if torrent.HashString == nil || torrent.DownloadDir == nil || torrent.Name == nil {
+			continue
+		}
+		// File names are relative to the download directory and include the torrent root folder
+		files := make([]string, len(torrent.Files))
+		for i, file := range torrent.Files {
+			files[i] = path.Join(*torrent.DownloadDir, file.Name)
+		}
+		contents = append(contents, TorrentContent{
+			Hash:  *torrent.HashString,
+			Path:  path.Join(*torrent.DownloadDir, *torrent.Name),
+			Files: files,
+		})
+	}
+	return contents, nil
+}
+
+func (tc TransmissionClient) ListTorrents(ctx context.Context) ([]TorrentContent, error) {
+	return tc.contents(ctx)
+}
+
+func (tc TransmissionClient) ExistingTorrents(hashes []string) ([]string, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	torrents, err := tc.transmissionClient.TorrentGetAllForHashes(context.Background(), hashes)
+	if err != nil {
+		return nil, err
+	}
+	var found []string
+	for _, torrent := range torrents {
+		if torrent.HashString != nil {
+			found = append(found, *torrent.HashString)
+		}
+	}
+	return matchHashes(hashes, found), nil
+}
+
+func (tc TransmissionClient) TorrentStats(ctx context.Context, hashes []string) (map[string]TorrentStats, error) {
+	torrents, err := tc.transmissionClient.TorrentGetAllForHashes(ctx, hashes)
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]TorrentStats, len(torrents))
+	for _, torrent := range torrents {
+		if torrent.HashString == nil {
+			continue
+		}
+		torrentStats := TorrentStats{Hash: *torrent.HashString}
+		if torrent.UploadRatio != nil {
+			torrentStats.Ratio = *torrent.UploadRatio
+		}
+		if torrent.TimeSeeding != nil {
+			torrentStats.SeedingTime = *torrent.TimeSeeding
+		}
+		if torrent.IsPrivate != nil {
+			torrentStats.Private = *torrent.IsPrivate
+		}
+		for _, tracker := range torrent.Trackers {
+			torrentStats.Trackers = append(torrentStats.Trackers, tracker.Announce)
+		}
+		stats[strings.ToUpper(*torrent.HashString)] = torrentStats
+	}
+	return stats, nil
 }
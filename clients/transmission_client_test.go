@@ -2,9 +2,11 @@ package clients
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/hekmon/transmissionrpc/v3"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -27,6 +29,30 @@ func (m *MockTransmissionRPC) TorrentGetAllForHashes(ctx context.Context, hashes
 	return args.Get(0).([]transmissionrpc.Torrent), args.Error(1)
 }
 
+func (m *MockTransmissionRPC) TorrentGet(ctx context.Context, fields []string, ids []int64) (torrents []transmissionrpc.Torrent, err error) {
+	args := m.Called(ctx, fields, ids)
+	return args.Get(0).([]transmissionrpc.Torrent), args.Error(1)
+}
+
+func (m *MockTransmissionRPC) TorrentStopIDs(ctx context.Context, ids []int64) (err error) {
+	args := m.Called(ctx, ids)
+	return args.Error(0)
+}
+
+func (m *MockTransmissionRPC) TorrentStartIDs(ctx context.Context, ids []int64) (err error) {
+	args := m.Called(ctx, ids)
+	return args.Error(0)
+}
+
+func (m *MockTransmissionRPC) TorrentSet(ctx context.Context, payload transmissionrpc.TorrentSetPayload) (err error) {
+	args := m.Called(ctx, payload)
+	return args.Error(0)
+}
+
+func transmissionContent(hashString string, downloadDir string, name string) transmissionrpc.Torrent {
+	return transmissionrpc.Torrent{HashString: &hashString, DownloadDir: &downloadDir, Name: &name}
+}
+
 func TestTransmissionRemove(t *testing.T) {
 	mockTransmissionClient := new(MockTransmissionRPC)
 	id2 := int64(22)
@@ -35,11 +61,65 @@ func TestTransmissionRemove(t *testing.T) {
 	mockTransmissionClient.On("TorrentGetAllForHashes", mock.Anything, removeHashes).Return([]transmissionrpc.Torrent{
 		{ID: &id2, HashString: &hashString2},
 	}, nil)
+	mockTransmissionClient.On("TorrentGet", mock.Anything, mock.Anything, []int64(nil)).Return([]transmissionrpc.Torrent{
+		transmissionContent(hashString2, "/downloads", "Show.S01"),
+	}, nil)
 	mockTransmissionClient.On("TorrentRemove", mock.Anything, transmissionrpc.TorrentRemovePayload{IDs: []int64{id2}, DeleteLocalData: true}).Return(nil)
 
 	client := TransmissionClient{transmissionClient: mockTransmissionClient}
 
-	client.RemoveTorrents([]string{"AAA65110BA16EF7839C27604B41AB083C832D83C", "BBB65110BA16EF7839C27604B41AB083C832D83C"})
+	results := client.RemoveTorrents(context.Background(), []string{"AAA65110BA16EF7839C27604B41AB083C832D83C", "BBB65110BA16EF7839C27604B41AB083C832D83C"})
+
+	mock.AssertExpectationsForObjects(t, mockTransmissionClient)
+	assert.Equal(t, RemoveResults{
+		{Hash: "AAA65110BA16EF7839C27604B41AB083C832D83C", Status: StatusNotFound},
+		{Hash: "BBB65110BA16EF7839C27604B41AB083C832D83C", Status: StatusRemoved},
+	}, results)
+}
+
+func TestTransmissionRemoveFailure(t *testing.T) {
+	mockTransmissionClient := new(MockTransmissionRPC)
+	id := int64(11)
+	hashString := "aaa65110ba16ef7839c27604b41ab083c832d83c"
+	removeHashes := []string{"AAA65110BA16EF7839C27604B41AB083C832D83C"}
+	removeErr := errors.New("connection reset")
+	mockTransmissionClient.On("TorrentGetAllForHashes", mock.Anything, removeHashes).Return([]transmissionrpc.Torrent{
+		{ID: &id, HashString: &hashString},
+	}, nil)
+	mockTransmissionClient.On("TorrentRemove", mock.Anything, transmissionrpc.TorrentRemovePayload{IDs: []int64{id}, DeleteLocalData: true}).Return(removeErr)
+
+	client := TransmissionClient{transmissionClient: mockTransmissionClient, sharedData: SharedDataDelete}
+
+	results := client.RemoveTorrents(context.Background(), removeHashes)
+
+	mock.AssertExpectationsForObjects(t, mockTransmissionClient)
+	assert.Equal(t, RemoveResults{
+		{Hash: "AAA65110BA16EF7839C27604B41AB083C832D83C", Status: StatusFailed, Err: removeErr},
+	}, results)
+}
+
+func TestTransmissionRemoveCrossSeed(t *testing.T) {
+	mockTransmissionClient := new(MockTransmissionRPC)
+	id := int64(11)
+	hashString := "aaa65110ba16ef7839c27604b41ab083c832d83c"
+	crossSeedHashString := "ccc65110ba16ef7839c27604b41ab083c832d83c"
+	removeHashes := []string{"AAA65110BA16EF7839C27604B41AB083C832D83C"}
+	mockTransmissionClient.On("TorrentGetAllForHashes", mock.Anything, removeHashes).Return([]transmissionrpc.Torrent{
+		{ID: &id, HashString: &hashString},
+	}, nil)
+	mockTransmissionClient.On("TorrentGet", mock.Anything, mock.Anything, []int64(nil)).Return([]transmissionrpc.Torrent{
+		transmissionContent(hashString, "/downloads", "Movie.2024.1080p"),
+		transmissionContent(crossSeedHashString, "/downloads", "Movie.2024.1080p"),
+	}, nil)
+	// Data is still seeded by the cross-seed torrent
+	mockTransmissionClient.On("TorrentRemove", mock.Anything, transmissionrpc.TorrentRemovePayload{IDs: []int64{id}, DeleteLocalData: false}).Return(nil)
+
+	client := TransmissionClient{transmissionClient: mockTransmissionClient, sharedData: SharedDataKeep}
+
+	results := client.RemoveTorrents(context.Background(), removeHashes)
 
 	mock.AssertExpectationsForObjects(t, mockTransmissionClient)
+	assert.Equal(t, RemoveResults{
+		{Hash: "AAA65110BA16EF7839C27604B41AB083C832D83C", Status: StatusRemoved},
+	}, results)
 }
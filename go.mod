@@ -6,6 +6,7 @@ require (
 	github.com/autobrr/go-qbittorrent v1.16.0
 	github.com/hekmon/transmissionrpc/v3 v3.0.0
 	github.com/stretchr/testify v1.11.1
+	go.etcd.io/bbolt v1.4.3
 )
 
 require (
@@ -30,6 +31,6 @@ require (
 	github.com/pmezard/go-difflib v1.0.1-0.20181226105442-5d4384ee4fb2 // indirect
 	github.com/sirupsen/logrus v1.9.4
 	golang.org/x/net v0.56.0 // indirect
-	golang.org/x/sys v0.46.0 // indirect
+	golang.org/x/sys v0.46.0
 	gopkg.in/yaml.v3 v3.0.1
 )
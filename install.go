@@ -0,0 +1,32 @@
+package main
+
+import (
+	"arrcoon/arrs"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Registers arrcoon as a Custom Script connection of the *arr, or removes it along with the index
+func install(binDir string, config Config, arrName string, uninstall bool) bool {
+	var connectable arrs.Connectable
+	switch arrName {
+	case "sonarr":
+		connectable = arrs.NewSonarr(binDir, config.Sonarr.Host, config.Sonarr.Token, nil)
+	case "radarr":
+		connectable = arrs.NewRadarr(binDir, config.Radarr.Host, config.Radarr.Token, nil)
+	default:
+		log.Error("Usage: arrcoon install|uninstall -arr <sonarr|radarr>")
+		return false
+	}
+	if uninstall {
+		return connectable.Uninstall()
+	}
+	// The *arr runs the binary it was installed with, i.e. <arr config>/arrcoon/arrcoon
+	scriptPath, err := os.Executable()
+	if err != nil {
+		log.WithError(err).Error("Couldn't get arrcoon binary path")
+		return false
+	}
+	return connectable.Install(scriptPath)
+}
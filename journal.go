@@ -0,0 +1,30 @@
+package main
+
+import (
+	"arrcoon/arrs"
+	"encoding/json"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Prints journal entries as JSON lines, limited to the entries mentioning the hash when given
+func journal(binDir string, positional []string) bool {
+	var hash string
+	if len(positional) > 0 {
+		hash = positional[0]
+	}
+	entries, err := arrs.NewJournal(binDir).Entries(hash)
+	if err != nil {
+		log.WithError(err).Error("Couldn't read journal")
+		return false
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			log.WithError(err).Error("Couldn't print journal entry")
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"arrcoon/arrs"
+	"arrcoon/clients"
+	"context"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Reports torrents which aren't referenced by any configured *arr, removing them when asked to
+func orphans(ctx context.Context, binDir string, config Config, torrentClient clients.TorrentClient, remove bool) bool {
+	var libraries []arrs.Library
+	if config.Sonarr.Host != "" {
+		libraries = append(libraries, arrs.NewSonarr(binDir, config.Sonarr.Host, config.Sonarr.Token, torrentClient))
+	}
+	if config.Radarr.Host != "" {
+		libraries = append(libraries, arrs.NewRadarr(binDir, config.Radarr.Host, config.Radarr.Token, torrentClient))
+	}
+	if config.Lidarr.Host != "" {
+		libraries = append(libraries, arrs.NewLidarr(binDir, config.Lidarr.Host, config.Lidarr.Token, torrentClient))
+	}
+	if config.Readarr.Host != "" {
+		libraries = append(libraries, arrs.NewReadarr(binDir, config.Readarr.Instance, config.Readarr.Host, config.Readarr.Token, torrentClient))
+	}
+
+	orphanedTorrents, err := arrs.FindOrphans(ctx, torrentClient, libraries)
+	if err != nil {
+		log.WithError(err).Error("Couldn't scan for orphaned torrents")
+		return false
+	}
+	hashes := make([]string, len(orphanedTorrents))
+	for i, torrent := range orphanedTorrents {
+		hashes[i] = torrent.Hash
+		log.WithFields(log.Fields{
+			"Hash":         torrent.Hash,
+			"Content Path": torrent.Path,
+		}).Info("Orphaned torrent")
+	}
+	if !remove || len(hashes) == 0 {
+		return true
+	}
+	results := torrentClient.RemoveTorrents(ctx, hashes)
+	if failed := results.Failed(); len(failed) > 0 {
+		log.WithFields(log.Fields{
+			"Hashes": failed,
+		}).Error("Couldn't remove orphaned torrents")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"arrcoon/arrs"
+	"arrcoon/clients"
+	"context"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Restores torrents and index entries removed according to the journal.
+// Accepts either a torrent hash or an *arr index name followed by a series, movie, artist or author id.
+func restore(ctx context.Context, binDir string, torrentClient *clients.QueuedClient, positional []string) bool {
+	journal := arrs.NewJournal(binDir)
+	var restores []arrs.Restore
+	var err error
+	switch len(positional) {
+	case 1:
+		restores, err = journal.RestoresForHash(positional[0])
+	case 2:
+		itemId, convErr := strconv.Atoi(positional[1])
+		if convErr != nil {
+			log.WithError(convErr).Error("Failed to convert restored item id to int")
+			return false
+		}
+		restores, err = journal.RestoresForItem(positional[0], itemId)
+	default:
+		log.Error("Usage: arrcoon restore <hash> | arrcoon restore <sonarr|radarr|lidarr|readarr> <id>")
+		return false
+	}
+	if err != nil {
+		log.WithError(err).Error("Couldn't read journal")
+		return false
+	}
+	if len(restores) == 0 {
+		log.WithFields(log.Fields{
+			"Arguments": positional,
+		}).Warn("Nothing to restore, no journaled removals found")
+		return false
+	}
+
+	success := arrs.RestoreIndex(binDir, restores)
+	var hashes []string
+	seen := make(map[string]struct{})
+	for _, restore := range restores {
+		for _, hash := range restore.Hashes {
+			if _, ok := seen[hash]; !ok {
+				seen[hash] = struct{}{}
+				hashes = append(hashes, hash)
+			}
+		}
+	}
+	for _, result := range torrentClient.RestoreTorrents(ctx, hashes) {
+		switch result.Status {
+		case clients.StatusRestored, clients.StatusDryRun:
+			log.WithFields(log.Fields{
+				"Hash": result.Hash,
+			}).Info("Torrent has been restored")
+		case clients.StatusNotFound:
+			// Only quarantined torrents are still in the client, removed ones have to be grabbed again
+			log.WithFields(log.Fields{
+				"Hash": result.Hash,
+			}).Warn("Torrent isn't in the torrent client any longer, only its index entry was restored")
+		default:
+			log.WithError(result.Err).WithFields(log.Fields{
+				"Hash": result.Hash,
+			}).Error("Couldn't restore torrent")
+			success = false
+		}
+	}
+	return success
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"arrcoon/arrs"
+	"arrcoon/clients"
+	"context"
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const DEFAULT_LISTEN_ADDRESS = ":9898"
+
+// Upper bound of handling a single webhook event, including pending removal retries
+const eventTimeout = 10 * time.Minute
+
+type webhookParser func(body []byte) (string, arrs.EventVars, error)
+
+type Server struct {
+	config        Config
+	torrentClient *clients.QueuedClient
+	sonarr        *arrs.Sonarr
+	radarr        *arrs.Radarr
+	lidarr        *arrs.Lidarr
+	readarr       *arrs.Readarr
+	// Events are handled one at a time as they share the index and torrent client sessions
+	mutex sync.Mutex
+}
+
+func NewServer(binDir string, config Config, torrentClient *clients.QueuedClient) *Server {
+	server := &Server{
+		config:        config,
+		torrentClient: torrentClient,
+	}
+	if config.Sonarr.Host != "" {
+		server.sonarr = arrs.NewSonarr(binDir, config.Sonarr.Host, config.Sonarr.Token, torrentClient)
+		server.sonarr.SetIndexOptions(config.indexOptions())
+		server.sonarr.SetRemovalPolicy(config.removalPolicy())
+	}
+	if config.Radarr.Host != "" {
+		server.radarr = arrs.NewRadarr(binDir, config.Radarr.Host, config.Radarr.Token, torrentClient)
+		server.radarr.SetIndexOptions(config.indexOptions())
+		server.radarr.SetRemovalPolicy(config.removalPolicy())
+	}
+	if config.Lidarr.Host != "" {
+		server.lidarr = arrs.NewLidarr(binDir, config.Lidarr.Host, config.Lidarr.Token, torrentClient)
+		server.lidarr.SetIndexOptions(config.indexOptions())
+		server.lidarr.SetRemovalPolicy(config.removalPolicy())
+	}
+	if config.Readarr.Host != "" {
+		server.readarr = arrs.NewReadarr(binDir, config.Readarr.Instance, config.Readarr.Host, config.Readarr.Token, torrentClient)
+		server.readarr.SetIndexOptions(config.indexOptions())
+		server.readarr.SetRemovalPolicy(config.removalPolicy())
+	}
+	return server
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	if s.sonarr != nil {
+		mux.Handle("POST /sonarr", s.webhookHandler("Sonarr", s.sonarr, arrs.SonarrWebhookVars))
+	}
+	if s.radarr != nil {
+		mux.Handle("POST /radarr", s.webhookHandler("Radarr", s.radarr, arrs.RadarrWebhookVars))
+	}
+	if s.lidarr != nil {
+		mux.Handle("POST /lidarr", s.webhookHandler("Lidarr", s.lidarr, arrs.LidarrWebhookVars))
+	}
+	if s.readarr != nil {
+		mux.Handle("POST /readarr", s.webhookHandler("Readarr", s.readarr, arrs.ReadarrWebhookVars))
+	}
+	return mux
+}
+
+func (s *Server) webhookHandler(arrName string, handler arrs.EventHandler, parse webhookParser) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="arrcoon"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.WithError(err).Error("Couldn't read webhook payload")
+			http.Error(w, "Couldn't read payload", http.StatusBadRequest)
+			return
+		}
+		eventType, vars, err := parse(body)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"Arr": arrName,
+			}).Error("Couldn't decode webhook payload")
+			http.Error(w, "Couldn't decode payload", http.StatusBadRequest)
+			return
+		}
+
+		// A dropped connection mustn't abort removals halfway, leaving torrents and the index out of sync
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), eventTimeout)
+		defer cancel()
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		// The server is a single long running invocation, pending removals are retried with every event
+		s.torrentClient.Drain(ctx)
+		if !handleEvent(ctx, s.config, s.torrentClient, arrName, handler, eventType, vars) {
+			http.Error(w, "Event handling failed", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// Periodically converges the torrent clients back to the library state, as events get missed while the *arr or arrcoon is down
+func (s *Server) runScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sweep(ctx)
+		}
+	}
+}
+
+func (s *Server) sweep(ctx context.Context) {
+	log.Info("Starting scheduled sweep")
+	s.mutex.Lock()
+	s.torrentClient.Drain(ctx)
+	s.mutex.Unlock()
+	// Index reconciles don't touch the torrent clients, events are only held back while removing torrents
+	for _, sweeper := range s.sweepers() {
+		sweeper.Sweep(ctx, &s.mutex)
+	}
+}
+
+func (s *Server) sweepers() []arrs.Sweeper {
+	var sweepers []arrs.Sweeper
+	if s.sonarr != nil {
+		sweepers = append(sweepers, s.sonarr)
+	}
+	if s.radarr != nil {
+		sweepers = append(sweepers, s.radarr)
+	}
+	if s.lidarr != nil {
+		sweepers = append(sweepers, s.lidarr)
+	}
+	if s.readarr != nil {
+		sweepers = append(sweepers, s.readarr)
+	}
+	return sweepers
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.config.Server.Username == "" && s.config.Server.Password == "" {
+		return true
+	}
+	username, password, ok := r.BasicAuth()
+	// Constant time comparisons don't leak how much of the credentials matched
+	usernameMatch := subtle.ConstantTimeCompare([]byte(username), []byte(s.config.Server.Username)) == 1
+	passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(s.config.Server.Password)) == 1
+	return ok && usernameMatch && passwordMatch
+}
+
+// Runs arrcoon as a long-running webhook receiver
+func serve(binDir string, config Config, torrentClient *clients.QueuedClient) bool {
+	listen := config.Server.Listen
+	if listen == "" {
+		listen = DEFAULT_LISTEN_ADDRESS
+	}
+	server := NewServer(binDir, config, torrentClient)
+	if config.Server.SweepInterval != "" {
+		interval, err := time.ParseDuration(config.Server.SweepInterval)
+		if err != nil || interval <= 0 {
+			log.WithError(err).WithFields(log.Fields{
+				"Sweep Interval": config.Server.SweepInterval,
+			}).Error("Invalid sweep interval")
+			return false
+		}
+		log.WithFields(log.Fields{
+			"Sweep Interval": interval,
+		}).Info("Scheduling sweeps")
+		go server.runScheduler(context.Background(), interval)
+	}
+	log.WithFields(log.Fields{
+		"Listen": listen,
+	}).Info("Starting arrcoon webhook server")
+	err := http.ListenAndServe(listen, server.Handler())
+	if err != nil {
+		log.WithError(err).Error("Webhook server stopped")
+		return false
+	}
+	return true
+}